@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"uwDavid/moviedb/internal/data"
+)
+
+// The movieETag() helper returns the entity tag for a movie. The version number goes
+// up with every change to the movie, and the rating count and average cover the
+// changes made by reviews, which don't touch the version. The poster and alternate
+// titles don't touch the version either, so they're added when the movie has them.
+func movieETag(movie *data.Movie) string {
+	etag := fmt.Sprintf("%d-%d-%.2f", movie.Version, movie.RatingCount, movie.AverageRating)
+	if movie.Poster != "" {
+		etag += "-" + string(movie.Poster)
+	}
+	if len(movie.Titles) > 0 {
+		etag += "-" + movie.Titles.Hash()
+	}
+	return `"` + etag + `"`
+}
+
+// The moviesETag() helper returns a weak entity tag for a page of movies. It's a hash
+// of the ID and entity tag of each movie on the page, of the pagination metadata and of
+// any facet counts, so it changes whenever a movie on the page changes, movies join or
+// leave the page, or the counts change. The locale of each movie's title goes into it
+// too, so the movies should be localized first. It's weak because things like the
+// search highlights aren't part of it, but they can't change without the version
+// changing too.
+func moviesETag(movies []*data.Movie, metadata data.Metadata, facets data.Facets) string {
+	h := sha256.New()
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d:%s:%s,", movie.ID, movieETag(movie), movie.TitleLocale)
+	}
+	fmt.Fprintf(h, "%+v", metadata)
+	fmt.Fprintf(h, "%+v", facets)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// The variantETag() helper returns the entity tag for one variant of a resource's
+// representation, like a movie with only some of its fields or with related resources
+// embedded, by adding the parts that make up the variant to the resource's tag after
+// a "~". Each variant gets a tag of its own, since their bodies differ, while still
+// working in an If-Match header for changing the resource (see etagMatches()). Empty
+// parts are left out, so the full representation keeps the plain tag.
+func variantETag(etag string, parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "~" + strings.Join(nonEmpty, "~") + `"`
+}
+
+// The localeVariant() helper returns the part of an entity tag (see variantETag()) for
+// a movie that has been localized, naming the locale of its title. It's empty when the
+// movie has its original title.
+func localeVariant(movie *data.Movie) string {
+	if movie.TitleLocale == "" {
+		return ""
+	}
+	return "l=" + movie.TitleLocale
+}
+
+// The variantBase() helper strips the variant parts off an entity tag made by
+// variantETag(), giving back the resource's own tag.
+func variantBase(tag string) string {
+	base, _, found := strings.Cut(tag, "~")
+	if !found {
+		return tag
+	}
+	return base + `"`
+}
+
+// The etagMatches() helper reports whether an If-Match header value matches the
+// current entity tag. The header may contain "*" (which matches any current
+// representation) or a comma-separated list of entity tags. If-Match uses the strong
+// comparison, so weak tags like W/"3" never match. The tag of any variant of the
+// current representation matches too, as it was made from the same version of the
+// resource.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag || variantBase(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The etagMatchesWeak() helper is the If-None-Match version of etagMatches(), which
+// uses the weak comparison and so ignores the W/ prefix on either side.
+func etagMatchesWeak(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// The checkNotModified() helper handles a conditional GET. It sets the ETag header,
+// and the Last-Modified header if lastModified isn't zero, then checks whether the
+// client's cached copy is still current. If it is, it sends a 304 Not Modified
+// response and returns true, and the handler has nothing more to do. As the spec asks,
+// If-Modified-Since is only looked at when there's no If-None-Match header.
+func (app *application) checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	notModified := false
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		notModified = etagMatchesWeak(header, etag)
+	} else if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		// HTTP dates only go down to the second, so we drop the fraction from ours
+		// before comparing. An unparseable date is ignored.
+		since, err := http.ParseTime(header)
+		notModified = err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// The checkIfMatch() helper checks the If-Match precondition of a request against the
+// current entity tag of the resource it changes. It sends a 412 Precondition Failed
+// response if the client's copy is out of date, or a 428 Precondition Required response
+// if the header is missing and we've been configured to insist on it, and returns
+// false in either case. Handlers should stop if it returns false.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if app.config.etag.requireIfMatch {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+	if !etagMatches(header, etag) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
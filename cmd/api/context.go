@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/jwt"
 )
 
 type contextKey string
@@ -13,6 +14,14 @@ type contextKey string
 // request context.
 const userContextKey = contextKey("user")
 
+// tokenContextKey is the key for the authentication token that the request was made
+// with, for the handlers which manage the user's sessions.
+const tokenContextKey = contextKey("token")
+
+// claimsContextKey is the key for the claims of a signed authentication token. It's
+// only set when the request was made with one.
+const claimsContextKey = contextKey("claims")
+
 // contextSetUser() returns a copy of the req w/ the provided User struct
 // added to context
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -32,3 +41,31 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetToken() returns a copy of the req w/ the authentication token added to
+// context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken() retrieves the authentication token from the req context. It's
+// empty for anonymous users.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// contextSetClaims() returns a copy of the req w/ the claims of a signed token added
+// to context.
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims() retrieves the claims of a signed token from the req context, or
+// nil if the request wasn't made with one.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
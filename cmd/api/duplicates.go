@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+// The findDuplicate() helper returns an existing movie that the new movie is likely a
+// duplicate of, or nil if there isn't one or duplicate detection is turned off.
+func (app *application) findDuplicate(movie *data.Movie) (*data.Movie, error) {
+	if app.config.duplicates.threshold <= 0 {
+		return nil, nil
+	}
+	existing, err := app.models.Movies.FindDuplicate(movie, app.config.duplicates.threshold)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return existing, nil
+}
+
+// The mergeMoviesHandler() folds a duplicate movie into the movie in the URL, which is
+// the one that's kept. Afterwards the duplicate's ID redirects to it.
+func (app *application) mergeMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		DuplicateID int64 `json:"duplicate_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.DuplicateID > 0, "duplicate_id", "must be provided")
+	v.Check(input.DuplicateID != id, "duplicate_id", "must not be the movie it is merged into")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	unused, err := app.models.Movies.Merge(id, input.DuplicateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if unused != "" {
+		app.deletePoster(unused)
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie, "merged_id": input.DuplicateID}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The movieNotFoundResponse() helper is for when a movie can't be found. If the ID
+// belonged to a duplicate that was merged into another movie, we send a 301 Moved
+// Permanently response pointing there instead of a 404 Not Found.
+func (app *application) movieNotFoundResponse(w http.ResponseWriter, r *http.Request, id int64) {
+	to, err := app.models.Movies.GetRedirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	location := fmt.Sprintf("/v1/movies/%d", to)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, location, http.StatusMovedPermanently)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
+	"uwDavid/moviedb/internal/data"
 )
 
 // The logError() method is a generic helper for logging an error message.
@@ -85,6 +87,13 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// The invalidRefreshTokenResponse() method is for a refresh token that can't be used,
+// after which the client has to sign in again with the user's password.
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or already used refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "You must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -99,3 +108,30 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the %q content type is not supported, use one of: %s", r.Header.Get("Content-Type"), strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The duplicateMovieResponse() method sends a 409 Conflict response for a new movie
+// that looks like a duplicate, including the existing movie so that the client can
+// check.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, existing *data.Movie) {
+	message := "a movie with a similar title from the same year already exists, send force=true to add it anyway"
+	err := app.writeJSON(w, http.StatusConflict, envelope{"error": message, "existing": existing}, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been changed since you fetched it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header with the resource's ETag"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
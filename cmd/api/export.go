@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+// exportFlushInterval is how often an export pushes the rows written so far out to the
+// client. Each flush also moves the connection's write deadline on by
+// exportWriteWindow, so that a long export isn't cut off by the server's WriteTimeout
+// while a stalled client still is. The flushes run on a timer rather than as rows come
+// in, so the deadline keeps moving while the database is slow to produce rows too.
+const (
+	exportFlushInterval = time.Second
+	exportWriteWindow   = 30 * time.Second
+)
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	criteria := app.readMovieFilters(qs, v)
+	format := app.readString(qs, "format", "ndjson")
+	data.ValidateMovieFilters(v, criteria)
+	v.Check(validator.In(format, "ndjson", "csv"), "format", "must be ndjson or csv")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The export is cut off after the configured timeout, or as soon as the client
+	// goes away.
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.export.timeout)
+	defer cancel()
+
+	// Pick an encoder for the format. Both write through to the http.ResponseWriter
+	// (the csv.Writer via a small buffer), so nothing like the whole export is held
+	// in memory.
+	var (
+		write func(*data.Movie) error
+		flush func() error
+	)
+	switch format {
+	case "csv":
+		// The header row sits in the csv.Writer's buffer until the first flush, so
+		// it doesn't stop us sending an error response if the query fails.
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+		write = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.FormatInt(int64(movie.Year), 10),
+				strconv.FormatInt(int64(movie.Runtime), 10),
+				strings.Join(movie.Genres, ","),
+				strconv.FormatInt(int64(movie.Version), 10),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+	default:
+		enc := json.NewEncoder(w)
+		write = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		flush = func() error {
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+	}
+	// The status code is sent before the first row, so an error part way through
+	// can't be reported with it. Instead we announce an Export-Status trailer, which
+	// tells the client whether it received every row.
+	w.Header().Set("Trailer", "Export-Status")
+
+	// The write deadline is moved on before the query starts, as the first rows can
+	// take a while to arrive.
+	rc := http.NewResponseController(w)
+	err := app.extendExportDeadline(rc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Rows are written from the Export() callback, while a ticker flushes them out to
+	// the client and extends the write deadline every exportFlushInterval, and mu
+	// stops the two from using the http.ResponseWriter at the same time. Nothing is
+	// flushed before the first row, so that a failed query can still get an error
+	// response. If a flush fails, the next row stops the export.
+	var (
+		mu       sync.Mutex
+		started  bool
+		flushErr error
+	)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(exportFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				switch {
+				case flushErr != nil:
+				case started:
+					flushErr = app.flushExport(rc, flush)
+				default:
+					flushErr = app.extendExportDeadline(rc)
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+	err = app.models.Movies.Export(ctx, criteria, func(movie *data.Movie) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if flushErr != nil {
+			return flushErr
+		}
+		started = true
+		return write(movie)
+	})
+	close(done)
+	<-stopped
+	if err != nil && !started {
+		w.Header().Del("Trailer")
+		w.Header().Del("Content-Disposition")
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err != nil {
+		// Send the client whatever rows we managed to encode before the error.
+		app.logError(r, err)
+		flush()
+		w.Header().Set("Export-Status", "incomplete")
+		return
+	}
+	err = app.flushExport(rc, flush)
+	if err != nil {
+		app.logError(r, err)
+		w.Header().Set("Export-Status", "incomplete")
+		return
+	}
+	w.Header().Set("Export-Status", "complete")
+}
+
+// The flushExport() helper pushes the buffered rows of an export out to the client and
+// extends the write deadline for the rows still to come.
+func (app *application) flushExport(rc *http.ResponseController, flush func() error) error {
+	err := flush()
+	if err != nil {
+		return err
+	}
+	err = app.extendExportDeadline(rc)
+	if err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// The extendExportDeadline() helper moves the connection's write deadline on by
+// exportWriteWindow. Connections that don't support deadlines are left as they are.
+func (app *application) extendExportDeadline(rc *http.ResponseController) error {
+	err := rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+// movieIncludeSafelist holds the related resources that can be embedded in movies
+// with the include query string parameter.
+var movieIncludeSafelist = []string{"credits", "reviews"}
+
+// embeddedReviews is how many of a movie's most recent reviews are embedded with
+// include=reviews. Clients can page through the rest at /v1/movies/:id/reviews.
+const embeddedReviews = 10
+
+// The readMovieFields() helper reads the fields and include query string parameters,
+// like fields=id,title,year and include=credits. Without a fields parameter, every
+// field is sent.
+func (app *application) readMovieFields(qs url.Values, v *validator.Validator) (data.MovieFields, []string) {
+	fields := data.MovieFields(app.readCSV(qs, "fields", nil))
+	for _, name := range fields {
+		v.Check(validator.In(name, data.MovieFieldSafelist...), "fields", "invalid field value")
+	}
+	include := app.readCSV(qs, "include", []string{})
+	for _, name := range include {
+		v.Check(validator.In(name, movieIncludeSafelist...), "include", "invalid include value")
+	}
+	v.Check(validator.Unique(include), "include", "must not contain duplicate values")
+	return fields, include
+}
+
+// related holds the related resources embedded in a set of movies, keyed by movie ID.
+// A nil map means that the resource wasn't asked for.
+type related struct {
+	Credits map[int64][]*data.Credit `json:"credits,omitempty"`
+	Reviews map[int64][]*data.Review `json:"reviews,omitempty"`
+}
+
+// The loadRelated() helper fetches the related resources named in include for the
+// movies, with one query for each kind of resource however many movies there are.
+func (app *application) loadRelated(movies []*data.Movie, include []string) (related, error) {
+	var rel related
+	if len(include) == 0 || len(movies) == 0 {
+		return rel, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+	var err error
+	for _, name := range include {
+		switch name {
+		case "credits":
+			rel.Credits, err = app.models.Credits.GetAllForMovies(ids)
+		case "reviews":
+			rel.Reviews, err = app.models.Reviews.GetRecentForMovies(ids, embeddedReviews)
+		}
+		if err != nil {
+			return related{}, err
+		}
+	}
+	return rel, nil
+}
+
+// The variant() method returns a hash of the related resources, for telling apart
+// representations with different resources embedded (see variantETag()), since the
+// resources can change without the movies changing. It's empty when nothing is
+// embedded.
+func (rel related) variant() (string, error) {
+	if rel.Credits == nil && rel.Reviews == nil {
+		return "", nil
+	}
+	js, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(js)
+	return "r" + hex.EncodeToString(sum[:4]), nil
+}
+
+// The fieldsVariant() helper returns the part of an entity tag (see variantETag()) for
+// a sparse fieldset, which lists the fields in sorted order. It's empty when every
+// field is sent.
+func fieldsVariant(fields data.MovieFields) string {
+	if fields == nil {
+		return ""
+	}
+	sorted := slices.Clone(fields)
+	slices.Sort(sorted)
+	return "f=" + strings.Join(slices.Compact(sorted), ",")
+}
+
+// The presentMovie() helper returns a movie as it should be sent: with only the
+// fields that were asked for, and with its related resources embedded. If neither
+// was asked for, the movie is sent as it is.
+func presentMovie(movie *data.Movie, fields data.MovieFields, rel related) (interface{}, error) {
+	if fields == nil && rel.Credits == nil && rel.Reviews == nil {
+		return movie, nil
+	}
+
+	// Going through JSON means that the field names and formats (like the runtime
+	// and poster URL) always match the full representation.
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(js, &object)
+	if err != nil {
+		return nil, err
+	}
+	if fields != nil {
+		for name := range object {
+			switch name {
+			case "id":
+				// The ID is always sent, so the movie can be told apart from others.
+			case "original_title", "title_locale":
+				if !fields.Has("title") {
+					delete(object, name)
+				}
+			default:
+				if !fields.Has(name) {
+					delete(object, name)
+				}
+			}
+		}
+	}
+
+	result := make(map[string]interface{}, len(object)+2)
+	for name, value := range object {
+		result[name] = value
+	}
+	if rel.Credits != nil {
+		result["credits"] = rel.Credits[movie.ID]
+	}
+	if rel.Reviews != nil {
+		result["reviews"] = rel.Reviews[movie.ID]
+	}
+	return result, nil
+}
+
+// The presentMovies() helper is presentMovie() for a page of movies.
+func presentMovies(movies []*data.Movie, fields data.MovieFields, rel related) ([]interface{}, error) {
+	presented := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		var err error
+		presented[i], err = presentMovie(movie, fields, rel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return presented, nil
+}
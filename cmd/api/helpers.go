@@ -9,19 +9,26 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"uwDavid/moviedb/internal/data"
 	"uwDavid/moviedb/internal/validator"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readIntParam(r, "id")
+}
+
+// The readIntParam() helper reads a positive integer URL parameter, such as the
+// version in /v1/movies/:id/revert/:version.
+func (app *application) readIntParam(r *http.Request, name string) (int64, error) {
 	// parameters are stored in req context as well
 	params := httprouter.ParamsFromContext(r.Context())
 
 	// use ByName() to get value of param from the slice
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -184,6 +191,33 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readBool() helper reads a boolean value ("true" or "false", or any of the other
+// forms that strconv.ParseBool() understands) from the query string, returning the
+// default value if there isn't one. If the value isn't a boolean, we record an error
+// message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// The readRange() helper reads a pair of <key>_min and <key>_max integer values from
+// the query string into a data.Range. Missing values leave that end of the range
+// open, and any conversion errors are recorded in the provided Validator instance.
+func (app *application) readRange(qs url.Values, key string, v *validator.Validator) data.Range {
+	return data.Range{
+		Min: app.readInt(qs, key+"_min", 0, v),
+		Max: app.readInt(qs, key+"_max", 0, v),
+	}
+}
+
 // background() helper accepts an arbitrary func as a parameter
 func (app *application) background(fn func()) {
 	//increment waitgroup counter
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+// Imports can be a lot bigger than the 1MB that readJSON() allows.
+const maxImportBytes = 50 << 20
+
+// The import modes. In all_or_nothing mode a single invalid row rejects the whole
+// import, while skip_invalid inserts the valid rows and reports the rest.
+const (
+	importAllOrNothing = "all_or_nothing"
+	importSkipInvalid  = "skip_invalid"
+)
+
+// importLine holds a movie parsed from one line of an import, or the errors that
+// stopped us from parsing it.
+type importLine struct {
+	line   int
+	movie  *data.Movie
+	errors map[string]string
+}
+
+// importRow is the report entry for a single line of an import.
+type importRow struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type importReport struct {
+	Mode     string      `json:"mode"`
+	Total    int         `json:"total"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Imported int64       `json:"imported"`
+	Rows     []importRow `json:"rows"`
+}
+
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	mode := app.readString(r.URL.Query(), "mode", importAllOrNothing)
+	force := app.readBool(r.URL.Query(), "force", false, v)
+	v.Check(validator.In(mode, importAllOrNothing, importSkipInvalid), "mode", "must be all_or_nothing or skip_invalid")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Pick the parser for the body from the Content-Type header.
+	var parse func(io.Reader) ([]importLine, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		parse = readMovieNDJSON
+	case "text/csv":
+		parse = readMovieCSV
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/x-ndjson", "text/csv")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	lines, err := parse(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxImportBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if len(lines) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	// Validate every movie that parsed, in the same way as createMovieHandler. We load
+	// the genre catalogue once, rather than for every movie.
+	catalogue, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	report := importReport{Mode: mode, Total: len(lines)}
+	rows := make([]importRow, len(lines))
+	for i, line := range lines {
+		rows[i] = importRow{Line: line.line, Status: "accepted", Errors: line.errors}
+		if rows[i].Errors == nil {
+			v := validator.New()
+			data.ValidateMovie(v, line.movie)
+			if data.ValidateMovieGenres(v, line.movie, catalogue); !v.Valid() {
+				rows[i].Errors = v.Errors
+			}
+		}
+	}
+	// As with createMovieHandler, force=true imports likely duplicates too.
+	if !force {
+		err = app.rejectImportDuplicates(lines, rows)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	var movies []*data.Movie
+	for i := range rows {
+		if rows[i].Errors != nil {
+			rows[i].Status = "rejected"
+			report.Rejected++
+		} else {
+			movies = append(movies, lines[i].movie)
+			report.Accepted++
+		}
+	}
+	report.Rows = rows
+
+	// In all_or_nothing mode any rejected row means that nothing is imported, so we
+	// mark the valid rows as skipped and send the report with a 422 status.
+	if mode == importAllOrNothing && report.Rejected > 0 {
+		for i := range report.Rows {
+			if report.Rows[i].Status == "accepted" {
+				report.Rows[i].Status = "skipped"
+			}
+		}
+		report.Accepted = 0
+		err = app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"import": report}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(movies) > 0 {
+		report.Imported, err = app.models.Movies.InsertMany(movies, app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The rejectImportDuplicates() helper rejects the valid rows of an import which are
+// likely duplicates, adding the error to their report entries. A row is a duplicate of
+// an earlier row with the same year and the same title apart from case and punctuation,
+// or of a movie we already have (see findDuplicates()), which are looked up with one
+// query for the whole import. It does nothing if duplicate detection is turned off.
+func (app *application) rejectImportDuplicates(lines []importLine, rows []importRow) error {
+	if app.config.duplicates.threshold <= 0 {
+		return nil
+	}
+
+	seen := make(map[string]int)
+	var (
+		candidates []*data.Movie
+		indexes    []int
+	)
+	for i, line := range lines {
+		if rows[i].Errors != nil {
+			continue
+		}
+		key := importKey(line.movie)
+		if first, ok := seen[key]; ok {
+			rows[i].Errors = map[string]string{"title": fmt.Sprintf("is a duplicate of line %d", first)}
+			continue
+		}
+		seen[key] = line.line
+		candidates = append(candidates, line.movie)
+		indexes = append(indexes, i)
+	}
+
+	existing, err := app.models.Movies.FindDuplicates(candidates, app.config.duplicates.threshold)
+	if err != nil {
+		return err
+	}
+	for j, id := range existing {
+		if id != 0 {
+			rows[indexes[j]].Errors = map[string]string{"title": fmt.Sprintf("is likely a duplicate of movie %d", id)}
+		}
+	}
+	return nil
+}
+
+// The importKey() helper returns what two rows of an import must have in common to be
+// copies of each other: the year, and the title without case or punctuation, which is
+// what a similarity of 1 means for movies we already have.
+func importKey(movie *data.Movie) string {
+	words := strings.FieldsFunc(strings.ToLower(movie.Title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return fmt.Sprintf("%d %s", movie.Year, strings.Join(words, " "))
+}
+
+// The readMovieNDJSON() helper parses newline-delimited JSON, where each non-blank line
+// is a movie object in the same format that createMovieHandler accepts. Lines which
+// aren't valid JSON are reported rather than failing the whole import.
+func readMovieNDJSON(body io.Reader) ([]importLine, error) {
+	var lines []importLine
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&input)
+		if err != nil {
+			lines = append(lines, importLine{line: n, errors: map[string]string{"line": "invalid JSON: " + err.Error()}})
+			continue
+		}
+		lines = append(lines, importLine{line: n, movie: &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}})
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, errors.New("body contains a line longer than 1048576 bytes")
+		}
+		return nil, err
+	}
+	return lines, nil
+}
+
+// The readMovieCSV() helper parses CSV with a header row naming the title, year,
+// runtime and genres columns, in any order. The runtime is a number of minutes (with
+// or without a " mins" suffix), and genres are comma-separated within their field.
+func readMovieCSV(body io.Reader) ([]importLine, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header must contain a %q column", name)
+		}
+	}
+
+	var lines []importLine
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		// A row with the wrong number of fields is reported like any other bad row,
+		// but anything else (such as a stray quote) leaves us unable to carry on.
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) && errors.Is(parseError.Err, csv.ErrFieldCount) {
+			lines = append(lines, importLine{line: parseError.Line, errors: map[string]string{"line": "wrong number of fields"}})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		n, _ := reader.FieldPos(0)
+
+		errs := make(map[string]string)
+		movie := &data.Movie{Title: strings.TrimSpace(record[columns["title"]])}
+		year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+		if err != nil {
+			errs["year"] = "must be an integer value"
+		}
+		movie.Year = int32(year)
+		runtime := strings.TrimSuffix(strings.TrimSpace(record[columns["runtime"]]), " mins")
+		minutes, err := strconv.ParseInt(runtime, 10, 32)
+		if err != nil {
+			errs["runtime"] = "must be an integer number of minutes"
+		}
+		movie.Runtime = data.Runtime(minutes)
+		if genres := strings.TrimSpace(record[columns["genres"]]); genres != "" {
+			for _, genre := range strings.Split(genres, ",") {
+				movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if len(errs) > 0 {
+			lines = append(lines, importLine{line: n, errors: errs})
+			continue
+		}
+		lines = append(lines, importLine{line: n, movie: movie})
+	}
+	return lines, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+func (app *application) listUserListsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Every user has a watchlist, which we create the first time they look.
+	err := app.models.Lists.EnsureWatchlist(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	lists, err := app.models.Lists.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lists": lists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string `json:"name"`
+		Public bool   `json:"public"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+		Public: input.Public,
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readList() helper fetches the list named by the :id URL parameter and checks
+// that the current user may see it, or change it if modify is true. Public lists can
+// be seen by anyone, but only the owner of a list, or a user with the lists:admin
+// permission, can see a private list or change any list. Lists that the user can't
+// see are reported as not found, so that we don't give away that they exist. It
+// returns nil after sending a response.
+func (app *application) readList(w http.ResponseWriter, r *http.Request, modify bool) *data.List {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	list, err := app.models.Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	user := app.contextGetUser(r)
+	if list.UserID == user.ID || (list.Public && !modify) {
+		return list
+	}
+	permissions, err := app.models.Permissions.GetALlForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	switch {
+	case permissions.Include("lists:admin"):
+		return list
+	case list.Public:
+		app.notPermittedResponse(w, r)
+	default:
+		app.notFoundResponse(w, r)
+	}
+	return nil
+}
+
+// The writeList() helper sends a list along with its entries.
+func (app *application) writeList(w http.ResponseWriter, r *http.Request, list *data.List) {
+	entries, err := app.models.Lists.GetEntries(list.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	list.Entries = entries
+	list.EntryCount = len(entries)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, false)
+	if list == nil {
+		return
+	}
+	app.writeList(w, r, list)
+}
+
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, true)
+	if list == nil {
+		return
+	}
+
+	var input struct {
+		Name   *string `json:"name"`
+		Public *bool   `json:"public"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflit):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeList(w, r, list)
+}
+
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, true)
+	if list == nil {
+		return
+	}
+	if list.Watchlist {
+		app.badRequestResponse(w, r, errors.New("the watchlist can't be deleted"))
+		return
+	}
+
+	err := app.models.Lists.Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, true)
+	if list == nil {
+		return
+	}
+
+	// The position is optional, and the movie goes on the end of the list without it.
+	var input struct {
+		MovieID  int64 `json:"movie_id"`
+		Position int   `json:"position"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.AddEntry(list.ID, input.MovieID, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must refer to an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEntry):
+			v.AddError("movie_id", "is already on this list")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeList(w, r, list)
+}
+
+func (app *application) removeListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, true)
+	if list == nil {
+		return
+	}
+	movieID, err := app.readIntParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Lists.RemoveEntry(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeList(w, r, list)
+}
+
+// The reorderListEntriesHandler() puts the entries of a list in a new order, given as
+// the full list of movie IDs.
+func (app *application) reorderListEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	list := app.readList(w, r, true)
+	if list == nil {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Lists.Reorder(list.ID, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEntriesMismatch):
+			v := validator.New()
+			v.AddError("movie_ids", "must contain each movie on the list exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeList(w, r, list)
+}
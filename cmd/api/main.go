@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"uwDavid/moviedb/internal/data"
 	"uwDavid/moviedb/internal/jsonlog"
+	"uwDavid/moviedb/internal/jwt"
 	"uwDavid/moviedb/internal/mailer"
+	"uwDavid/moviedb/internal/storage"
 
 	_ "github.com/lib/pq"
 )
@@ -53,15 +56,64 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// text search configuration for movie search, and whether to rebuild the
+	// search vectors with it at startup
+	search struct {
+		config  string
+		reindex bool
+	}
+	// longest time a single catalogue export may run
+	export struct {
+		timeout time.Duration
+	}
+	// how long deleted movies stay in the trash, and how often to purge it
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	// whether changes to a movie must carry an If-Match header
+	etag struct {
+		requireIfMatch bool
+	}
+	// where uploaded files are kept, and the largest poster image we accept
+	storage struct {
+		dir string
+	}
+	posters struct {
+		maxBytes int64
+	}
+	// how similar a new movie's title must be to one from the same year to count as
+	// a likely duplicate
+	duplicates struct {
+		threshold float64
+	}
+	// how long access tokens and refresh tokens last, and whether access tokens are
+	// opaque (looked up in the database) or signed (self-contained), along with the
+	// keys to sign them with (or the file they're in) and how often to reload the
+	// list of revoked ones
+	tokens struct {
+		accessTTL       time.Duration
+		refreshTTL      time.Duration
+		mode            string
+		signingKeys     []jwt.Key
+		signingKeysFile string
+		revocationSync  time.Duration
+	}
 }
 
 // app struct to hold dependencies for HTTP handler, helpers, and middleware
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	// signer and revoked are only set in the signed token mode
+	signer  *jwt.Signer
+	revoked *revocationList
+	// touches throttles recording the use of authentication tokens
+	touches touchList
+	wg      sync.WaitGroup
 }
 
 func main() {
@@ -101,6 +153,30 @@ func main() {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
 	})
+	// search config
+	flag.StringVar(&cfg.search.config, "search-config", data.DefaultSearchConfig, "PostgreSQL text search configuration for movie search")
+	flag.BoolVar(&cfg.search.reindex, "search-reindex", false, "Rebuild movie search vectors with the search configuration at startup")
+	flag.DurationVar(&cfg.export.timeout, "export-timeout", 10*time.Minute, "Maximum duration of a movie catalogue export")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired movies from the trash (0 to disable)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files such as movie posters")
+	flag.Int64Var(&cfg.posters.maxBytes, "poster-max-bytes", 10<<20, "Maximum size of an uploaded poster image in bytes")
+	flag.Float64Var(&cfg.duplicates.threshold, "duplicate-threshold", 0.8, "Title similarity (0-1) at which a new movie from the same year is a likely duplicate (0 to disable)")
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 15*time.Minute, "How long authentication (access) tokens last")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "How long refresh tokens last")
+	flag.StringVar(&cfg.tokens.mode, "token-mode", "opaque", "Kind of authentication tokens to issue (opaque|signed)")
+	// Signing keys are given as space-separated id=secret pairs, with base64
+	// secrets. The first one signs new tokens. They're best kept in a file or the
+	// TOKEN_SIGNING_KEYS environment variable (see readSigningKeys()), as anyone on
+	// the machine can read the command line.
+	flag.StringVar(&cfg.tokens.signingKeysFile, "token-signing-keys-file", "", "File holding the keys for signed tokens, as id=base64secret pairs separated by spaces or newlines (first signs); recommended over TOKEN_SIGNING_KEYS and -token-signing-keys")
+	flag.Func("token-signing-keys", "Keys for signed tokens, as space-separated id=base64secret pairs (first signs); visible in the process list, so prefer -token-signing-keys-file", func(val string) error {
+		keys, err := jwt.ParseKeys(val)
+		cfg.tokens.signingKeys = keys
+		return err
+	})
+	flag.DurationVar(&cfg.tokens.revocationSync, "token-revocation-sync", 30*time.Second, "How often to reload the list of revoked signed tokens")
+	flag.BoolVar(&cfg.etag.requireIfMatch, "require-if-match", false, "Reject movie updates and deletes without an If-Match header")
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
 	if *displayVersion {
@@ -135,12 +211,58 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db)
+	models.Movies.SearchConfig = cfg.search.config
+
+	// After switching to a different search configuration the stored search vectors
+	// need rebuilding before searches will match them.
+	if cfg.search.reindex {
+		n, err := models.Movies.ReindexSearch()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("movie search vectors rebuilt", map[string]string{
+			"config": cfg.search.config,
+			"movies": strconv.FormatInt(n, 10),
+		})
+	}
+
+	// Uploaded files are kept on the local filesystem.
+	files, err := storage.NewLocal(cfg.storage.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// initialize app struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  models,
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: files,
+	}
+
+	// In the signed token mode we need the signing keys, and the current list of
+	// revoked tokens before we start taking requests.
+	switch cfg.tokens.mode {
+	case "opaque":
+	case "signed":
+		keys, err := readSigningKeys(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		app.signer, err = jwt.NewSigner(keys...)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		app.models.Tokens.RecordRevocations = true
+		app.revoked = &revocationList{}
+		err = app.loadRevocations()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid token mode %q", cfg.tokens.mode), nil)
 	}
 
 	/* move server config to server.go
@@ -167,6 +289,24 @@ func main() {
 }
 
 // openDB() helper
+// The readSigningKeys() helper returns the keys for signed tokens. Flags can be seen by
+// anyone who can list the processes on the machine, so the keys are read from the
+// -token-signing-keys-file file if there is one, or else the TOKEN_SIGNING_KEYS
+// environment variable, and only then from the -token-signing-keys flag.
+func readSigningKeys(cfg config) ([]jwt.Key, error) {
+	if cfg.tokens.signingKeysFile != "" {
+		contents, err := os.ReadFile(cfg.tokens.signingKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.ParseKeys(string(contents))
+	}
+	if env := os.Getenv("TOKEN_SIGNING_KEYS"); env != "" {
+		return jwt.ParseKeys(env)
+	}
+	return cfg.tokens.signingKeys, nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/jwt"
 	"uwDavid/moviedb/internal/validator"
 
 	"github.com/felixge/httpsnoop"
@@ -169,6 +170,21 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		// signed tokens carry everything we need to know about the user, so they're
+		// checked without going to the database
+		if app.signer != nil && jwt.LooksSigned(token) {
+			claims, err := app.signer.Verify(token, time.Now())
+			if err != nil || app.revoked.contains(claims.ID) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			user := &data.User{ID: claims.UserID, Name: claims.Name, Activated: claims.Activated}
+			r = app.contextSetUser(r, user)
+			r = app.contextSetClaims(r, claims)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		// if token isn't valid => send InvlaidAuthenticationTokenResponse
@@ -189,8 +205,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
-		// set user to req context
+		// record that the token is still in use, for the user's list of sessions
+		app.touchToken(token)
+		// set user and token to req context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		// call next handler in the chain
 		next.ServeHTTP(w, r)
 	})
@@ -243,11 +262,19 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetALlForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		// a signed token lists the user's permissions as they were when it was
+		// issued, which saves looking them up
+		var permissions data.Permissions
+		if claims := app.contextGetClaims(r); claims != nil {
+			permissions = data.Permissions(claims.Permissions)
+		} else {
+			user := app.contextGetUser(r)
+			var err error
+			permissions, err = app.models.Permissions.GetALlForUser(user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		// check if permission exists
@@ -275,12 +302,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range app.config.cors.trustedOrigins {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					// let browser clients read the ETag so they can send it back
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 					// check if req HTTP method OPTIONS + contains request-method header
 					// if so, we treat it as a preflight request
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, If-Modified-Since")
 
 						// write 200 OK status, and return from middleware w/ no further actions
 						w.WriteHeader(http.StatusOK)
@@ -302,6 +331,9 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		totalRequestsReceived.Add(1)
+		// The writer that httpsnoop wraps around w has an Unwrap() method (since
+		// v1.0.3), which http.ResponseController needs to reach the connection's
+		// SetWriteDeadline() and Flush() when streaming exports (see export.go).
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
 		totalResponsesSent.Add(1)
@@ -3,7 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 	"uwDavid/moviedb/internal/data"
 	"uwDavid/moviedb/internal/validator"
 )
@@ -40,13 +44,35 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Use validator helper
 	v := validator.New()
+	// force=true adds the movie even if it looks like a duplicate.
+	force := app.readBool(r.URL.Query(), "force", false, v)
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	// The genres are checked against the genre catalogue too, which swaps them for
+	// their slugs.
+	data.ValidateMovie(v, movie)
+	err = app.validateMovieGenres(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	if !force {
+		existing, err := app.findDuplicate(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if existing != nil {
+			app.duplicateMovieResponse(w, r, existing)
+			return
+		}
+	}
+
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -72,19 +98,64 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// The title is given in the client's language when the movie has a title in it.
+	// fields and include choose the fields to send and the related resources to
+	// embed.
+	v := validator.New()
+	locales := app.readLocales(w, r, v)
+	fields, include := app.readMovieFields(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.movieNotFoundResponse(w, r, id)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	rel, err := app.loadRelated([]*data.Movie{movie}, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Send the movie's ETag and modification time, so that the client can make its
+	// changes conditional on nobody else having changed it first, and skip sending
+	// the movie again if the client's cached copy is still current. A sparse
+	// fieldset or embedded resources make a variant of the movie with an ETag of its
+	// own, which still works for If-Match. Embedded resources can change without the
+	// movie's modification time moving on, so there's no Last-Modified header with
+	// them.
+	relVariant, err := rel.variant()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// The title's locale is part of the variant too, as the same movie is sent with
+	// a different title depending on the client's language.
+	localizeMovie(movie, locales)
+	etag := variantETag(movieETag(movie), localeVariant(movie), fieldsVariant(fields), relVariant)
+	lastModified := movie.UpdatedAt
+	if len(include) > 0 {
+		lastModified = time.Time{}
+	}
+	if app.checkNotModified(w, r, etag, lastModified) {
+		return
+	}
+	presented, err := presentMovie(movie, fields, rel)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	// encode struct to JSON
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": presented}, nil)
 	if err != nil {
 		// serverErrorResponse() helper
 		app.serverErrorResponse(w, r, err)
@@ -110,50 +181,85 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	// Declare an input struct to hold the expected data from the client.
-	// We change to use pointers, so that we can check for nil values
-	// to enable partial updates
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// If the client sent an If-Match header, check that the movie hasn't changed since
+	// they fetched it, sending a 412 Precondition Failed response if it has.
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
 		return
 	}
+	// Merge patches and JSON Patches are applied by patchMovie(), while a plain JSON
+	// body holds just the fields to change, as before.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType, jsonPatchType:
+		err = app.patchMovie(w, r, mediaType, movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, errPatchTestFailed):
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+	case "", "application/json":
+		// Declare an input struct to hold the expected data from the client.
+		// We change to use pointers, so that we can check for nil values
+		// to enable partial updates
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	// validate body for any nil values
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+		// validate body for any nil values
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/json", mergePatchType, jsonPatchType)
+		return
 	}
 
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity
 	// response if any checks fail.
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	data.ValidateMovie(v, movie)
+	err = app.validateMovieGenres(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		// A conditional request whose movie changed after the If-Match check failed
+		// its precondition all the same.
+		case errors.Is(err, data.ErrEditConflit) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflit):
 			app.editConflictResponse(w, r)
 		default:
@@ -161,8 +267,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Write the updated movie record in a JSON response, along with its new ETag.
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -175,13 +283,34 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
+	// A conditional delete needs the movie's current version to check the If-Match
+	// header against, and only goes ahead if the movie is still at that version.
+	var version int32
+	if r.Header.Get("If-Match") != "" || app.config.etag.requireIfMatch {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !app.checkIfMatch(w, r, movieETag(movie)) {
+			return
+		}
+		version = movie.Version
+	}
 	// Delete the movie from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflit):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -198,8 +327,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// input struct to hold expected values from request query
 	// embeddd Filter struct
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
 	}
 	// Initialize a new Validator instance.
@@ -207,29 +335,170 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
-	// Use our helpers to extract the title and genres query string values, falling back
-	// to defaults of an empty string and an empty slice respectively if they are not
-	// provided by the client.
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilters = app.readMovieFilters(qs, v)
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	// Extract the sort query string value, falling back to "id" if it is not provided
-	// by the client (which will imply a ascending sort on movie ID).
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	// by the client (which will imply a ascending sort on movie ID). The value can list
+	// several comma-separated keys, like "-year,title".
+	sort := app.readCSV(qs, "sort", []string{"id"})
+	// Sorting by relevance always puts the best matches first, so we treat it as a
+	// descending sort on the search rank. rating is short for the average_rating
+	// column.
+	for i := range sort {
+		switch sort[i] {
+		case "relevance":
+			sort[i] = "-relevance"
+		case "rating", "-rating":
+			sort[i] = strings.Replace(sort[i], "rating", "average_rating", 1)
+		}
+	}
+	input.Filters.Sort = strings.Join(sort, ",")
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "average_rating", "-id", "-title", "-year", "-runtime", "-average_rating", "-relevance"}
+	// An opaque cursor from a previous response's metadata switches the listing over
+	// to keyset pagination.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	// facets asks for counts of the matching movies by genre and/or decade, alongside
+	// the page of movies.
+	facetNames := app.readCSV(qs, "facets", []string{})
+	// Titles are given in the client's language where the movies have them.
+	locales := app.readLocales(w, r, v)
+	// fields and include choose the fields to send and the related resources to
+	// embed in each movie.
+	fields, include := app.readMovieFields(qs, v)
 
 	// Check the Validator instance for any errors and use the failedValidationResponse()
 	// helper to send the client a response if necessary.
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	for _, name := range facetNames {
+		v.Check(validator.In(name, data.FacetSafelist...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facetNames), "facets", "must not contain duplicate values")
+	v.Check(input.Query != "" || !validator.In("-relevance", sort...), "sort", "relevance sort requires a q search query")
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	rel, err := app.loadRelated(movies, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// When a title filter or search finds nothing at all, suggest some similar titles
+	// that the client may have meant.
+	if len(movies) == 0 && input.Filters.Page == 1 && input.Filters.Cursor == "" {
+		term := input.Title
+		if term == "" {
+			term = input.Query
+		}
+		if term != "" {
+			metadata.DidYouMean, err = app.models.Movies.DidYouMean(term, 5)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+	// The facets are only included when asked for.
+	env := envelope{"metadata": metadata}
+	var facets data.Facets
+	if len(facetNames) > 0 {
+		facets, err = app.models.Movies.Facets(input.MovieFilters, facetNames)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+	// The page gets an ETag of its own, so that a client polling the same listing can
+	// be told it hasn't changed. There's no Last-Modified header, as a movie leaving
+	// the listing doesn't show up in the modification times of the ones left in it.
+	// The movies are localized first, as their titles' locales are part of the tag.
+	for _, movie := range movies {
+		localizeMovie(movie, locales)
+	}
+	relVariant, err := rel.variant()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	etag := variantETag(moviesETag(movies, metadata, facets), fieldsVariant(fields), relVariant)
+	if app.checkNotModified(w, r, etag, time.Time{}) {
+		return
+	}
+	env["movies"], err = presentMovies(movies, fields, rel)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readMovieFilters() helper reads the movie filtering criteria shared by the list
+// and export endpoints from the query string, recording any conversion errors in the
+// provided Validator instance.
+func (app *application) readMovieFilters(qs url.Values, v *validator.Validator) data.MovieFilters {
+	var f data.MovieFilters
+	// Use our helpers to extract the title and genres query string values, falling back
+	// to defaults of an empty string and an empty slice respectively if they are not
+	// provided by the client.
+	f.Title = app.readString(qs, "title", "")
+	// q is a full-text search query in websearch syntax ("quoted phrases", or,
+	// -negation), plus prefix terms such as "galax*".
+	f.Query = app.readString(qs, "q", "")
+	f.Genres = app.readCSV(qs, "genres", []string{})
+	// genres matches movies with all of the listed genres, while genres_any matches
+	// movies with at least one of them.
+	f.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	f.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+	// Read the year_min/year_max and runtime_min/runtime_max range filters.
+	f.Year = app.readRange(qs, "year", v)
+	f.Runtime = app.readRange(qs, "runtime", v)
+	// Read the person filter, which is the ID of someone credited on the movies.
+	f.Person = int64(app.readInt(qs, "person", 0, v))
+	// fuzzy=true lets the title filter match misspelled titles too.
+	f.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	return f
+}
+
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// The trash takes the same filters as the movie listing, and defaults to
+	// showing the most recently deleted movies first.
+	input.MovieFilters = app.readMovieFilters(qs, v)
+	input.Trash = true
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "deleted_at", "-id", "-title", "-year", "-runtime", "-deleted_at"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -240,3 +509,28 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	// Take the movie back out of the trash, sending a 404 Not Found response if it
+	// isn't in there.
+	movie, err := app.models.Movies.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"uwDavid/moviedb/internal/data"
+)
+
+// The media types for the two standard patch formats that PATCH /v1/movies/:id accepts
+// alongside plain JSON.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// errPatchTestFailed is returned when a JSON Patch test operation doesn't hold, which
+// means that the movie isn't in the state the client expected.
+var errPatchTestFailed = errors.New("patch test operation failed")
+
+// errPatchPathNotFound is returned by patchValue() when an operation's path doesn't
+// point at anything in the document.
+var errPatchPathNotFound = errors.New("path does not exist")
+
+// moviePatchDocument is the JSON document that patches are applied to. It has the same
+// fields as the update body, but without omitempty, so that every field has a value
+// for JSON Patch paths to point at.
+type moviePatchDocument struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// patchOperation is a single operation in an RFC 6902 JSON Patch. Value is left as raw
+// JSON so that we can tell a missing value apart from an explicit null.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// The patchMovie() helper reads a merge patch or JSON Patch (depending on mediaType) from
+// the request body and applies it to the movie. The movie is turned into a generic JSON
+// document, patched, and then decoded back again, so a patch can clear a field or add
+// a single genre. The result still needs validating.
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	js, err := json.Marshal(moviePatchDocument{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case mergePatchType:
+		var patch interface{}
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return errors.New("body must be a JSON object")
+		}
+		doc = mergePatch(doc, patch)
+	case jsonPatchType:
+		var ops []patchOperation
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			return err
+		}
+		for i, op := range ops {
+			doc, err = applyPatchOperation(doc, op)
+			if err != nil {
+				return fmt.Errorf("patch operation %d: %w", i, err)
+			}
+		}
+	}
+
+	// Decode the patched document, catching any fields the patch added that movies
+	// don't have, or values of the wrong type.
+	js, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var patched moviePatchDocument
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("patched movie contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("patched movie contains unknown key %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return errors.New("patched movie contains invalid runtime format")
+		default:
+			return errors.New("patched movie must be a JSON object")
+		}
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+	return nil
+}
+
+// The mergePatch() function applies an RFC 7396 merge patch to a document. Members of a
+// patch object replace the matching members of the document, except that nulls remove
+// them and nested objects are merged in the same way.
+func mergePatch(doc, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	docObject, ok := doc.(map[string]interface{})
+	if !ok {
+		docObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(docObject, key)
+			continue
+		}
+		docObject[key] = mergePatch(docObject[key], value)
+	}
+	return docObject
+}
+
+// The applyPatchOperation() function applies a single RFC 6902 operation to a document,
+// returning the new document. We support the add, remove, replace and test operations.
+func applyPatchOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("%s operation must have a value", op.Op)
+		}
+	case "remove":
+	case "move", "copy":
+		return nil, fmt.Errorf("the %q operation is not supported", op.Op)
+	default:
+		return nil, fmt.Errorf("invalid operation %q", op.Op)
+	}
+
+	var value interface{}
+	if len(op.Value) > 0 {
+		err := json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tokens, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err = patchValue(doc, tokens, op.Op, value)
+	if errors.Is(err, errPatchPathNotFound) {
+		return nil, fmt.Errorf("path %q does not exist", op.Path)
+	}
+	return doc, err
+}
+
+// The parseJSONPointer() function splits an RFC 6901 JSON Pointer like "/genres/0" into
+// its reference tokens, unescaping ~1 and ~0.
+func parseJSONPointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// The patchValue() function walks down the document along the pointer tokens and
+// applies the operation to the value at the end of them, returning the updated value.
+// It returns the new value rather than patching in place because adding to or
+// removing from an array can mean a new slice.
+func patchValue(doc interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op {
+		case "remove":
+			return nil, errors.New("cannot remove the whole movie")
+		case "test":
+			if !reflect.DeepEqual(doc, value) {
+				return nil, errPatchTestFailed
+			}
+			return doc, nil
+		default:
+			return value, nil
+		}
+	}
+
+	token, rest := tokens[0], tokens[1:]
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if len(rest) > 0 || op == "test" || op == "replace" || op == "remove" {
+			if !ok {
+				return nil, errPatchPathNotFound
+			}
+		}
+		if len(rest) == 0 && op == "remove" {
+			delete(container, token)
+			return container, nil
+		}
+		if len(rest) == 0 && op == "add" {
+			container[token] = value
+			return container, nil
+		}
+		child, err := patchValue(child, rest, op, value)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+	case []interface{}:
+		// "-" refers to the position after the last element, which is only somewhere
+		// we can add to.
+		if len(rest) == 0 && op == "add" && token == "-" {
+			return append(container, value), nil
+		}
+		index, err := strconv.Atoi(token)
+		limit := len(container)
+		if len(rest) == 0 && op == "add" {
+			limit++
+		}
+		if err != nil || index < 0 || index >= limit || token != strconv.Itoa(index) {
+			return nil, errPatchPathNotFound
+		}
+		if len(rest) == 0 {
+			switch op {
+			case "add":
+				container = append(container, nil)
+				copy(container[index+1:], container[index:])
+				container[index] = value
+				return container, nil
+			case "remove":
+				return append(container[:index], container[index+1:]...), nil
+			}
+		}
+		child, err := patchValue(container[index], rest, op, value)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	default:
+		return nil, errPatchPathNotFound
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/imaging"
+	"uwDavid/moviedb/internal/storage"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxPosterPixels caps the size of the poster images that we decode, since a small
+// compressed file can expand into a huge image in memory.
+const maxPosterPixels = 40_000_000
+
+// posterTypes holds the content types that we accept for posters, with the file
+// extension for each.
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// posterNameRX matches the names that updateMoviePosterHandler() gives posters, so
+// that we never pass anything else to the storage.
+var posterNameRX = regexp.MustCompile(`^[0-9]+-[0-9a-f]{16}\.(jpg|png)$`)
+
+// The readPoster() helper reads the image from a poster upload, which is either the
+// "poster" file of a multipart form or the whole request body. It returns the image
+// along with the content type that the client gave for it.
+func readPoster(r *http.Request) ([]byte, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body, err := io.ReadAll(r.Body)
+		return body, mediaType, err
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", errors.New(`body must contain a "poster" file`)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() != "poster" {
+			continue
+		}
+		body, err := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		return body, contentType, err
+	}
+}
+
+// The updateMoviePosterHandler() stores a new poster for a movie, along with resized
+// variants of it, and deletes the one it replaces. The image is named after the movie
+// and a hash of its contents, so each new poster gets new URLs and the files can be
+// cached forever.
+func (app *application) updateMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
+		return
+	}
+
+	// Images are much bigger than the JSON bodies that readJSON() limits to 1MB, so
+	// posters have a limit of their own.
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.posters.maxBytes)
+	body, contentType, err := readPoster(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("poster must not be larger than %d bytes", app.config.posters.maxBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if len(body) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain an image"))
+		return
+	}
+
+	// Check both the type the client gave and the type of the content itself, which
+	// must agree. Clients which don't know the type can send application/octet-stream.
+	if _, ok := posterTypes[contentType]; !ok && contentType != "" && contentType != "application/octet-stream" {
+		app.unsupportedMediaTypeResponse(w, r, "image/jpeg", "image/png", "multipart/form-data")
+		return
+	}
+	sniffed := http.DetectContentType(body)
+	ext, ok := posterTypes[sniffed]
+	switch {
+	case !ok:
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "the poster must be a JPEG or PNG image")
+		return
+	case posterTypes[contentType] != "" && contentType != sniffed:
+		app.badRequestResponse(w, r, fmt.Errorf("the poster was sent as %s but contains %s", contentType, sniffed))
+		return
+	}
+
+	v := validator.New()
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if v.Check(config.Width*config.Height <= maxPosterPixels, "poster", "must not be larger than 40 megapixels"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	img, format, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Store the original as it was uploaded, and the variants in the same format.
+	sum := sha256.Sum256(body)
+	poster := data.Poster(fmt.Sprintf("%d-%x%s", movie.ID, sum[:8], ext))
+	err = app.storage.Put(poster.Key(data.PosterOriginal), bytes.NewReader(body))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for variant, width := range data.PosterWidths {
+		var buf bytes.Buffer
+		err = imaging.Encode(&buf, imaging.Resize(img, width), format)
+		if err == nil {
+			err = app.storage.Put(poster.Key(variant), &buf)
+		}
+		if err != nil {
+			app.discardPoster(movie, poster)
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	old, err := app.models.Movies.SetPoster(movie.ID, poster)
+	if err != nil {
+		app.discardPoster(movie, poster)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if old != "" && old != poster {
+		app.deletePoster(old)
+	}
+	movie.Poster = poster
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie, "posters": poster.URLs()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deletePoster() helper deletes every variant of a poster from storage. The poster
+// is no longer in use by then, so failures are only logged.
+func (app *application) deletePoster(poster data.Poster) {
+	variants := []string{data.PosterOriginal}
+	for variant := range data.PosterWidths {
+		variants = append(variants, variant)
+	}
+	for _, variant := range variants {
+		err := app.storage.Delete(poster.Key(variant))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			app.logger.PrintError(err, map[string]string{"poster": poster.Key(variant)})
+		}
+	}
+}
+
+// The discardPoster() helper cleans up after a failed upload, deleting the new poster
+// unless it's the same image as the movie's current one.
+func (app *application) discardPoster(movie *data.Movie, poster data.Poster) {
+	if poster != movie.Poster {
+		app.deletePoster(poster)
+	}
+}
+
+// The showPosterHandler() serves a variant of a poster. Posters never change once
+// stored (a new poster gets a new name), so clients and proxies may cache them for as
+// long as they like. http.ServeContent() takes care of conditional and range requests.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	variant, name := params.ByName("variant"), params.ByName("name")
+	if _, ok := data.PosterWidths[variant]; (!ok && variant != data.PosterOriginal) || !posterNameRX.MatchString(name) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	poster := data.Poster(name)
+	f, modTime, err := app.storage.Open(poster.Key(variant))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+name+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, modTime, f)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// The purgeTrash() method starts a background job which permanently deletes movies
+// that have been in the trash for longer than the configured retention period,
+// checking once every purge interval. It returns a function which stops the job, and
+// does nothing if the interval is zero.
+func (app *application) purgeTrash() (stop func()) {
+	if app.config.trash.purgeInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+
+	app.background(func() {
+		ticker := time.NewTicker(app.config.trash.purgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n, posters, err := app.models.Movies.Purge(time.Now().Add(-app.config.trash.retention))
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					continue
+				}
+				for _, poster := range posters {
+					app.deletePoster(poster)
+				}
+				if n > 0 {
+					app.logger.PrintInfo("purged movies from trash", map[string]string{
+						"movies": strconv.FormatInt(n, 10),
+					})
+				}
+			}
+		}
+	})
+	return func() {
+		close(done)
+	}
+}
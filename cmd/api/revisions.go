@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// The history is paged like the movie listing, newest revision first by default.
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAllForMovie(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Every movie has at least one revision, so an empty first page means that there
+	// isn't a movie with this ID (or that it has been purged).
+	if len(revisions) == 0 && input.Filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The from and to query string parameters are the two versions to compare.
+	v := validator.New()
+	qs := r.URL.Query()
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", 0, v)
+	v.Check(from > 0, "from", "must be provided and greater than zero")
+	v.Check(to > 0, "to", "must be provided and greater than zero")
+	v.Check(from <= math.MaxInt32, "from", "must not be greater than 2147483647")
+	v.Check(to <= math.MaxInt32, "to", "must not be greater than 2147483647")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	fromRevision, err := app.models.Revisions.Get(id, int32(from))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	toRevision, err := app.models.Revisions.Get(id, int32(to))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	diff := envelope{
+		"from":    fromRevision,
+		"to":      toRevision,
+		"changes": data.DiffRevisions(fromRevision, toRevision),
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	version, err := app.readIntParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the movie as it is now, and the revision that we're reverting it to.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// A revert changes the movie like any other update, so it honours If-Match too.
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
+		return
+	}
+	revision, err := app.models.Revisions.Get(id, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A revert is saved as an ordinary update with the old field values, so it gets a
+	// new version (and revision) of its own, and goes through the same validation and
+	// edit conflict checks as any other update.
+	movie.Title = revision.Movie.Title
+	movie.Year = revision.Movie.Year
+	movie.Runtime = revision.Movie.Runtime
+	movie.Genres = revision.Movie.Genres
+
+	// The genre catalogue may have changed since the revision was saved, so the old
+	// genres have to be checked against it again.
+	v := validator.New()
+	data.ValidateMovie(v, movie)
+	err = app.validateMovieGenres(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		// A conditional request whose movie changed after the If-Match check failed
+		// its precondition all the same.
+		case errors.Is(err, data.ErrEditConflit) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflit):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Send the reverted movie along with its new ETag.
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
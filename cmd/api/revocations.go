@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// revocationList is this server's copy of the revoked_tokens table, so that signed
+// tokens can be checked against it without a database query. It's reloaded every so
+// often by syncRevocations(), which means that a token revoked through another server
+// can keep working here until the next reload.
+type revocationList struct {
+	mu  sync.RWMutex
+	ids map[string]time.Time
+}
+
+// contains() reports whether the token with the given ID has been revoked.
+func (l *revocationList) contains(id string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.ids[id]
+	return ok
+}
+
+// add() revokes a token straight away on this server, rather than waiting for the
+// next reload to pick it up.
+func (l *revocationList) add(id string, expiry time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ids == nil {
+		l.ids = make(map[string]time.Time)
+	}
+	l.ids[id] = expiry
+}
+
+// replace() swaps the list for a freshly loaded one. Tokens added since the load
+// started are kept, as long as they haven't expired, in case the load missed them.
+func (l *revocationList) replace(ids map[string]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for id, expiry := range l.ids {
+		if _, ok := ids[id]; !ok && expiry.After(now) {
+			ids[id] = expiry
+		}
+	}
+	l.ids = ids
+}
+
+// The loadRevocations() helper reloads the revocation list from the database.
+func (app *application) loadRevocations() error {
+	ids, err := app.models.Tokens.GetRevoked()
+	if err != nil {
+		return err
+	}
+	app.revoked.replace(ids)
+	return nil
+}
+
+// The syncRevocations() method starts a background job which reloads the revocation
+// list every sync interval, and deletes the revoked tokens that have expired. It
+// returns a function which stops the job, and does nothing unless signed tokens are
+// turned on.
+func (app *application) syncRevocations() (stop func()) {
+	if app.signer == nil || app.config.tokens.revocationSync <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+
+	app.background(func() {
+		ticker := time.NewTicker(app.config.tokens.revocationSync)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := app.models.Tokens.PurgeRevoked()
+				if err == nil {
+					err = app.loadRevocations()
+				}
+				if err != nil {
+					app.logger.PrintError(err, nil)
+				}
+			}
+		}
+	})
+	return func() {
+		close(done)
+	}
+}
@@ -22,19 +22,107 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	// bulk import from NDJSON or CSV, which shares its path with /v1/movies/:id (see
+	// staticParams()). There is no POST /v1/movies/:id itself.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticParams("id", map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+	}, app.methodNotAllowedResponse))
+	// export, autocomplete and the trash listing share their paths with /v1/movies/:id too
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticParams("id", map[string]http.HandlerFunc{
+		"export":       app.requirePermission("movies:read", app.exportMoviesHandler),
+		"autocomplete": app.requirePermission("movies:read", app.autocompleteMoviesHandler),
+		"trash":        app.requirePermission("movies:write", app.listTrashHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
 	// PATCH method for partial updates
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:read", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	// alternate titles in other languages
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/titles", app.requirePermission("movies:write", app.updateMovieTitlesHandler))
+	// posters, and the files for them, which anyone can fetch so that they work in
+	// <img> tags
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.updateMoviePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/posters/:variant/:name", app.showPosterHandler)
+	// fold a duplicate movie into this one
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermission("movies:admin", app.mergeMoviesHandler))
+	// take a deleted movie back out of the trash
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	// revision history, and reverting a movie to an earlier version
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/diff", app.requirePermission("movies:read", app.diffMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert/:version", app.requirePermission("movies:write", app.revertMovieHandler))
+	// credits link a movie to the people who worked on it
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.updateMovieCreditsHandler))
+	// reviews, which any activated user with movies:read can write (and then edit or
+	// delete their own)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.deleteMovieReviewHandler))
+
+	// people routes
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("people:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("people:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("people:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("people:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("people:write", app.deletePersonHandler))
+
+	// genre catalogue routes. Movies can only use the genres in the catalogue.
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("movies:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission("movies:write", app.deleteGenreHandler))
 
 	// user routes
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	// PUT method for idempotent updates
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	// consumes a password reset token, to set a new password
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	// the current user's watchlist and custom lists
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/lists", app.requireActivatedUser(app.listUserListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/lists", app.requireActivatedUser(app.createListHandler))
+
+	// the places where the current user is signed in
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.deleteSessionHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	// trades a refresh token in for a new authentication token and refresh token
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	// signs out, by revoking the token the request is made with
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	// sends a new activation email, for when the first one got lost or expired
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+
+	// list routes. Anyone can view a public list, and readList() checks the rest.
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id", app.showListHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists/:id/entries", app.requireActivatedUser(app.addListEntryHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/lists/:id/entries", app.requireActivatedUser(app.reorderListEntriesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id/entries/:movie_id", app.requireActivatedUser(app.removeListEntryHandler))
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
 }
+
+// httprouter doesn't allow a fixed path segment in the same position as a named
+// parameter, so a route like /v1/movies/export can't be registered alongside
+// /v1/movies/:id. The staticParams() helper wraps the handler for the parameter route,
+// and sends requests where the parameter is one of the fixed names to the handler for
+// that name instead.
+func (app *application) staticParams(param string, static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if handler, ok := static[params.ByName(param)]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
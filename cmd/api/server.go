@@ -21,6 +21,11 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Start the trash purge job, and the revoked token reloads for the signed token
+	// mode, which we stop again when the server shuts down.
+	stopPurge := app.purgeTrash()
+	stopRevocations := app.syncRevocations()
+
 	// Create a shutdownError channel. We will use this to receive any errors returned
 	// by the graceful Shutdown() function.
 	shutdownError := make(chan error)
@@ -57,6 +62,8 @@ func (app *application) serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
+		stopPurge()
+		stopRevocations()
 		// log message saying we're waiting for background goroutines to finish
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
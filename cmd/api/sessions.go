@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"uwDavid/moviedb/internal/data"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The deleteAuthenticationTokenHandler() signs the user out, by deleting the
+// authentication token that the request was made with, along with the rest of its
+// session's tokens.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if claims := app.contextGetClaims(r); claims != nil {
+		// A signed token is revoked on this server straight away, while the other
+		// servers pick it up from revoked_tokens.
+		err = app.models.Tokens.DeleteSession(claims.UserID, claims.SessionID)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			err = nil
+		}
+		app.revoked.add(claims.ID, time.Unix(claims.Expiry, 0))
+	} else {
+		err = app.models.Tokens.DeleteSessionForToken(app.contextGetToken(r))
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listSessionsHandler() lists the places where the user is signed in, meaning
+// their token families with an unexpired token.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessions(user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// A signed token's session is in its claims.
+	if claims := app.contextGetClaims(r); claims != nil {
+		for _, session := range sessions {
+			session.Current = session.ID == claims.SessionID
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteSessionHandler() signs the user out of one of their sessions, such as one
+// on a lost device. Deleting the current session is the same as signing out.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := app.models.Tokens.DeleteSession(user.ID, params.ByName("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/validator"
+)
+
+// The parseAcceptLanguage() helper returns the language tags in an Accept-Language
+// header, most preferred first. Tags with a q value of zero, and the "*" wildcard,
+// are left out, since either way we fall back to the original title.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, item := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		tag = strings.TrimSpace(tag)
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 || !data.LocaleRX.MatchString(tag) {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	locales := make([]string, len(tags))
+	for i, tag := range tags {
+		locales[i] = tag.tag
+	}
+	return locales
+}
+
+// The readLocales() helper returns the locales to pick movie titles for, in order of
+// preference. A lang query string parameter takes priority over the Accept-Language
+// header, and as the response depends on the header we add it to the Vary header.
+func (app *application) readLocales(w http.ResponseWriter, r *http.Request, v *validator.Validator) []string {
+	w.Header().Add("Vary", "Accept-Language")
+
+	if lang := app.readString(r.URL.Query(), "lang", ""); lang != "" {
+		v.Check(data.LocaleRX.MatchString(lang), "lang", "must be a language tag such as fr or pt-BR")
+		return []string{lang}
+	}
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// The localizeMovie() helper swaps a movie's title for its best alternate title for the
+// locales, keeping the original in OriginalTitle. If there's no title for any of the
+// locales, the movie is left with its original title.
+func localizeMovie(movie *data.Movie, locales []string) {
+	locale, ok := movie.Titles.Lookup(locales)
+	if !ok {
+		return
+	}
+	movie.OriginalTitle = movie.Title
+	movie.Title = movie.Titles[locale]
+	movie.TitleLocale = locale
+}
+
+// The updateMovieTitlesHandler() replaces all of a movie's alternate titles. An empty
+// object removes them.
+func (app *application) updateMovieTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
+		return
+	}
+
+	var input struct {
+		Titles data.Titles `json:"titles"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTitles(v, input.Titles); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.SetTitles(movie, input.Titles)
+	if err == nil {
+		movie, err = app.models.Movies.Get(movie.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		// As with other updates, a conditional request whose movie changed after the
+		// If-Match check failed its precondition all the same.
+		case errors.Is(err, data.ErrEditConflit) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflit):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
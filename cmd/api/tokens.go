@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"time"
 	"uwDavid/moviedb/internal/data"
+	"uwDavid/moviedb/internal/jwt"
 	"uwDavid/moviedb/internal/validator"
+
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -53,17 +56,246 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Otherwise, if the password is correct, we sign the user in with a short-lived
+	// authentication token and a refresh token for getting new ones. The client's IP
+	// address and user agent are kept with them, so that the user can tell their
+	// sessions apart.
+	access, refresh, err := app.models.Tokens.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	if err == nil {
+		err = app.signAccessToken(access, user)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Encode the tokens to JSON and send them in the response along with a 201
+	// Created status code.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The refreshAuthenticationTokenHandler() trades a refresh token in for a new
+// authentication token and refresh token. Each refresh token only works once, and
+// presenting one a second time signs the user out of that session altogether.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.RefreshToken)
+	if !v.Valid() {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.Refresh(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			// Log it, as it means someone has got hold of a user's tokens.
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{
+				"ip": realip.FromRequest(r),
+			})
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A signed token needs the user's details as they are now.
+	if app.signer != nil {
+		user, err := app.models.Users.Get(access.UserID)
+		if err == nil {
+			err = app.signAccessToken(access, user)
+		}
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The signAccessToken() helper swaps the plaintext of a new authentication token for a
+// signed token, when signed tokens are turned on. The signed token holds the user's
+// details and permissions, so changes to them only show up in tokens issued after the
+// change, and the token stands in for the opaque one in its session by using its ID.
+func (app *application) signAccessToken(token *data.Token, user *data.User) error {
+	if app.signer == nil {
+		return nil
+	}
+	permissions, err := app.models.Permissions.GetALlForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	signed, err := app.signer.Sign(jwt.Claims{
+		ID:          token.ID,
+		SessionID:   token.Family,
+		UserID:      user.ID,
+		Name:        user.Name,
+		Activated:   user.Activated,
+		Permissions: permissions,
+		IssuedAt:    token.CreatedAt.Unix(),
+		Expiry:      token.Expiry.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	token.Plaintext = signed
+	return nil
+}
+
+// passwordResetInterval is how long a user has to wait between password reset emails,
+// so that the endpoint can't be used to flood someone's inbox.
+const passwordResetInterval = 5 * time.Minute
+
+// The createPasswordResetTokenHandler() emails a password reset token to the user with
+// the email address in the request body. Any older reset tokens stop working. So that
+// nobody can use it to find out which email addresses have accounts, it always sends
+// the same 202 Accepted response, whether or not an email goes out, and does the work
+// in the background so that the response takes the same time too.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.background(func() {
+		err := app.sendPasswordResetToken(input.Email)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "if that email address belongs to an activated account, an email will be sent to it containing password reset instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The sendPasswordResetToken() helper does the work for
+// createPasswordResetTokenHandler(), in the background. Only activated users get a
+// token, since the others haven't shown that they own the email address yet, and like
+// activation emails they're throttled to one every passwordResetInterval. Anyone else
+// is quietly skipped.
+func (app *application) sendPasswordResetToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !user.Activated {
+		return nil
+	}
+
+	// Password reset tokens are short-lived, expiring after 45 minutes.
+	token, err := app.models.Tokens.Reissue(user.ID, 45*time.Minute, data.ScopePasswordReset, passwordResetInterval)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenThrottled) || errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	data := map[string]interface{}{
+		"passwordResetToken": token.Plaintext,
+	}
+	return app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+}
+
+// activationResendInterval is how long a user has to wait between activation emails,
+// so that the endpoint can't be used to flood someone's inbox.
+const activationResendInterval = 5 * time.Minute
+
+// The createActivationTokenHandler() sends a fresh activation token to the user with
+// the email address in the request body, in case the welcome email went missing or its
+// token expired. Any older activation tokens stop working. Like the password reset
+// endpoint, it sends the same 202 Accepted response whatever happens, so that it can't
+// be used to find out which email addresses have accounts, or which of those are
+// activated or being throttled.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
-	// Encode the token to JSON and send it in the response along with a 201 Created
-	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The work is done in the background, so that the response takes the same time
+	// whatever happens too.
+	app.background(func() {
+		err := app.resendActivationToken(input.Email)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "if that email address belongs to an account which isn't activated yet, an email will be sent to it containing activation instructions"}
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The resendActivationToken() helper does the work for createActivationTokenHandler(),
+// in the background. It quietly does nothing if there's no such user, they're
+// activated already, or they were sent a token less than activationResendInterval ago.
+func (app *application) resendActivationToken(email string) error {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Activated {
+		return nil
+	}
+
+	// Replace the user's activation tokens with a new one.
+	token, err := app.models.Tokens.Reissue(user.ID, activationTokenTTL, data.ScopeActivation, activationResendInterval)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenThrottled) || errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	data := map[string]interface{}{
+		"activationToken": token.Plaintext,
+	}
+	return app.mailer.Send(user.Email, "token_activation.tmpl", data)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// touchInterval is how often the use of an authentication token is recorded in the
+// database. The session list only needs to know roughly when a session was last used,
+// so this saves a write (and a round trip) on nearly every authenticated request.
+const touchInterval = time.Minute
+
+// touchList remembers when this server last recorded the use of each authentication
+// token, so that the authenticate() middleware can skip recording it again until
+// touchInterval has passed. Tokens are kept by their SHA-256 hash rather than in
+// plaintext, and the ones that are due again are swept out once an interval, so the
+// list only holds the tokens used in the last minute or so.
+type touchList struct {
+	mu        sync.Mutex
+	last      map[[32]byte]time.Time
+	lastSweep time.Time
+}
+
+// due() reports whether the use of the token should be recorded now, and if so notes
+// that it has been.
+func (l *touchList) due(token string, now time.Time) bool {
+	hash := sha256.Sum256([]byte(token))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last[hash]; ok && now.Sub(last) < touchInterval {
+		return false
+	}
+	if l.last == nil {
+		l.last = make(map[[32]byte]time.Time)
+	}
+	if now.Sub(l.lastSweep) >= touchInterval {
+		for h, last := range l.last {
+			if now.Sub(last) >= touchInterval {
+				delete(l.last, h)
+			}
+		}
+		l.lastSweep = now
+	}
+	l.last[hash] = now
+	return true
+}
+
+// The touchToken() helper records that an authentication token has just been used,
+// for the user's list of sessions. It's only written every touchInterval, and then in
+// the background, so the request doesn't wait on it. A failed write is only logged, as
+// the worst that happens is a slightly stale last_used_at.
+func (app *application) touchToken(token string) {
+	if !app.touches.due(token, time.Now()) {
+		return
+	}
+	app.background(func() {
+		err := app.models.Tokens.Touch(token)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
@@ -0,0 +1,9 @@
+-- The search vector is built with the deployment's text search configuration (the
+-- -search-config flag) when a movie is inserted or updated, so it is a plain column
+-- rather than a generated one. Run the API with -search-reindex after changing the
+-- configuration.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_vector tsvector;
+UPDATE movies SET search_vector = to_tsvector('english', title);
+ALTER TABLE movies ALTER COLUMN search_vector SET NOT NULL;
+DROP INDEX IF EXISTS movies_title_idx;
+CREATE INDEX IF NOT EXISTS movies_search_vector_idx ON movies USING GIN (search_vector);
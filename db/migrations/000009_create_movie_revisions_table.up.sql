@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    UNIQUE (movie_id, version)
+);
+-- Record the current state of the existing movies as their first revision.
+INSERT INTO movie_revisions (movie_id, version, action, title, year, runtime, genres)
+SELECT id, version, 'baseline', title, year, runtime, genres FROM movies;
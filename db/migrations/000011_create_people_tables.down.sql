@@ -0,0 +1,5 @@
+DELETE FROM users_permissions
+WHERE permission_id IN (SELECT id FROM permissions WHERE code IN ('people:read', 'people:write'));
+DELETE FROM permissions WHERE code IN ('people:read', 'people:write');
+DROP TABLE IF EXISTS movies_people;
+DROP TABLE IF EXISTS people;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    birth_year integer,
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+-- A credit links a person to a movie in a role. The same person can have more than
+-- one role in a movie (such as writer and director), but not the same one twice.
+CREATE TABLE IF NOT EXISTS movies_people (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('director', 'writer', 'actor')),
+    character text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0 CHECK (billing_order >= 0),
+    PRIMARY KEY (movie_id, person_id, role)
+);
+CREATE INDEX IF NOT EXISTS movies_people_person_id_idx ON movies_people (person_id);
+INSERT INTO permissions (code)
+VALUES
+('people:read'),
+('people:write');
+-- Existing users get the people permissions that match the movie permissions they
+-- already have, so that nobody loses access to the credits they could see before.
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT up.user_id, (SELECT id FROM permissions WHERE code = 'people:read')
+FROM users_permissions up
+JOIN permissions p ON p.id = up.permission_id
+WHERE p.code = 'movies:read'
+ON CONFLICT DO NOTHING;
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT up.user_id, (SELECT id FROM permissions WHERE code = 'people:write')
+FROM users_permissions up
+JOIN permissions p ON p.id = up.permission_id
+WHERE p.code = 'movies:write'
+ON CONFLICT DO NOTHING;
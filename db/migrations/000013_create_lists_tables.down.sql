@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'lists:admin';
+DROP TABLE IF EXISTS list_entries;
+DROP TABLE IF EXISTS lists;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    public boolean NOT NULL DEFAULT false,
+    watchlist boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists (user_id);
+-- Every user has exactly one watchlist.
+CREATE UNIQUE INDEX IF NOT EXISTS lists_watchlist_idx ON lists (user_id) WHERE watchlist;
+CREATE TABLE IF NOT EXISTS list_entries (
+    list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (list_id, movie_id)
+);
+CREATE INDEX IF NOT EXISTS list_entries_movie_id_idx ON list_entries (movie_id);
+-- lists:admin lets a user change anybody's lists.
+INSERT INTO permissions (code)
+VALUES
+('lists:admin');
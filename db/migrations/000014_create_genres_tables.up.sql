@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+-- Every spelling of a genre that we accept, including its own slug, maps to exactly
+-- one genre.
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+-- Seed the catalogue with the genres already in use, slugified the same way as
+-- data.Slugify(), and then rewrite the movie genres as slugs. Spellings that slugify
+-- the same (like "Sci-Fi" and "sci fi") become one genre, and duplicates within a
+-- movie are dropped, keeping the original order.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (slug) slug, genre
+FROM (
+    SELECT genre, trim(both '-' from regexp_replace(lower(genre), '[^[:alnum:]]+', '-', 'g')) AS slug
+    FROM movies, unnest(genres) AS genre
+) AS used
+WHERE slug <> ''
+ORDER BY slug, genre
+ON CONFLICT DO NOTHING;
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT slug, id FROM genres
+ON CONFLICT DO NOTHING;
+UPDATE movies SET genres = ARRAY(
+    SELECT slug
+    FROM (
+        SELECT trim(both '-' from regexp_replace(lower(genre), '[^[:alnum:]]+', '-', 'g')) AS slug, min(ord) AS ord
+        FROM unnest(movies.genres) WITH ORDINALITY AS used(genre, ord)
+        GROUP BY 1
+    ) AS slugs
+    WHERE slug <> ''
+    ORDER BY ord
+);
@@ -0,0 +1,2 @@
+-- The file name of the movie's poster in storage, or empty if it doesn't have one.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster text NOT NULL DEFAULT '';
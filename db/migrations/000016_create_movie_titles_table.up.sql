@@ -0,0 +1,8 @@
+-- Alternate titles of movies, one per locale (a BCP 47 language tag like "fr" or
+-- "pt-BR"). The movie's own title stays the original one.
+CREATE TABLE IF NOT EXISTS movie_titles (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL,
+    title text NOT NULL,
+    PRIMARY KEY (movie_id, locale)
+);
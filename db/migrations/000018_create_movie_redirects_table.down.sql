@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+DROP TABLE IF EXISTS movie_redirects;
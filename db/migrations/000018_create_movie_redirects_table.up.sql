@@ -0,0 +1,12 @@
+-- When a duplicate movie is merged into another one, its ID redirects to the movie it
+-- was merged into.
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    from_id bigint PRIMARY KEY,
+    to_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS movie_redirects_to_id_idx ON movie_redirects (to_id);
+-- movies:admin lets a user merge duplicate movies.
+INSERT INTO permissions (code)
+VALUES
+('movies:admin');
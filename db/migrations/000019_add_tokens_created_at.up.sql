@@ -0,0 +1,3 @@
+-- Record when each token was issued, so that activation emails can be throttled.
+-- Existing tokens are dated from now.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
@@ -0,0 +1,9 @@
+-- Authentication tokens double as sessions, which users can list and revoke. Each
+-- token gets an opaque ID to refer to it by, since the hash has to stay secret, and
+-- we record where it was issued and when it was last used.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id uuid NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE tokens ADD CONSTRAINT tokens_id_key UNIQUE (id);
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS ip text NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
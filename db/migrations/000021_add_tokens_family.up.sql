@@ -0,0 +1,9 @@
+-- A sign-in issues an access token and a refresh token, and every refresh replaces
+-- them with a new pair. All of the tokens from one sign-in share a family, which is
+-- what users see as a session. Used refresh tokens are kept, with used_at set, so that
+-- we can tell when one is presented again. Existing tokens are each a family of their
+-- own, with the same ID as before.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family uuid NOT NULL DEFAULT gen_random_uuid();
+UPDATE tokens SET family = id;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
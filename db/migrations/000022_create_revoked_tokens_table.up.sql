@@ -0,0 +1,8 @@
+-- In the signed token mode, authentication tokens are checked without looking them up,
+-- so deleting one isn't enough to stop it working. Instead, the IDs of deleted tokens
+-- are listed here until they would have expired anyway, and each server keeps a copy
+-- of the list in memory.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id uuid PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movie_titles_title_idx;
+DROP INDEX IF EXISTS movies_title_idx;
@@ -0,0 +1,6 @@
+-- The title filter matches whole words with the 'simple' configuration, in the
+-- original title and the alternate ones, separately from the search vector. This
+-- brings back the index from 000003 that 000007 dropped, and adds one for the
+-- alternate titles.
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_idx ON movie_titles USING GIN (to_tsvector('simple', title));
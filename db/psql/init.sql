@@ -5,6 +5,7 @@ CREATE DATABASE greenlight;
 CREATE ROLE greenlight WITH LOGIN PASSWORD 'pa55word';
 
 CREATE EXTENSION IF NOT EXISTS citext;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
 
 GRANT ALL ON SCHEMA public To greenlight;
 GRANT ALL PRIVILEGES ON DATABASE your_database TO your_user;
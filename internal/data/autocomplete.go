@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Suggestion is a lightweight match for a title prefix, for typeahead. Title is the
+// title that matched, which may be one of the movie's alternate titles.
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// The escapeLike() helper escapes the characters that are special in LIKE patterns, so
+// that s matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// titleCandidates is a query for every title of the live movies, original and
+// alternate, filtered by the %s condition on title. PostgreSQL pushes the condition
+// down into both halves of the union, so the trigram indexes still get used.
+const titleCandidates = `
+	SELECT id, title, year
+	FROM (
+		SELECT id, title, year, deleted_at FROM movies
+		UNION ALL
+		SELECT movies.id, movie_titles.title, movies.year, movies.deleted_at
+		FROM movie_titles
+		INNER JOIN movies ON movies.id = movie_titles.movie_id
+	) AS titles
+	WHERE deleted_at IS NULL AND %s`
+
+// Autocomplete() returns up to limit movies with a title that starts with prefix, or
+// has a word that does, falling back to titles which are similar to it so that small
+// typos still find something. Titles starting with the prefix come first, and then the
+// closest matches. Each movie is only suggested once, under its best matching title.
+func (m MovieModel) Autocomplete(prefix string, limit int) ([]*Suggestion, error) {
+	query := `
+		WITH candidates AS (` + fmt.Sprintf(titleCandidates, "(title ILIKE $1 OR title ILIKE ('% ' || $1) OR title %> $2)") + `
+		)
+		SELECT id, title, year
+		FROM (
+			SELECT DISTINCT ON (id) id, title, year, title ILIKE $1 AS starts, word_similarity($2, title) AS score
+			FROM candidates
+			ORDER BY id, title ILIKE $1 DESC, word_similarity($2, title) DESC, title
+		) AS best
+		ORDER BY starts DESC, score DESC, title, id
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(prefix)+"%", prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// DidYouMean() returns up to limit titles similar to a search term that found nothing,
+// most similar first, for suggesting a corrected spelling.
+func (m MovieModel) DidYouMean(term string, limit int) ([]string, error) {
+	query := `
+		WITH candidates AS (` + fmt.Sprintf(titleCandidates, "title %> $1") + `
+		)
+		SELECT title
+		FROM candidates
+		GROUP BY title
+		ORDER BY max(word_similarity($1, title)) DESC, title
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, term, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var title string
+		err := rows.Scan(&title)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
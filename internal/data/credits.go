@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// The roles that a person can be credited with on a movie.
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+// ErrUnknownPerson is returned when credits refer to a person who doesn't exist.
+var ErrUnknownPerson = errors.New("unknown person")
+
+// Credit links a person to a movie in a role. Character is the part played, for
+// actors, and BillingOrder sets the order that credits are listed in.
+type Credit struct {
+	PersonID     int64  `json:"person_id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billing_order"`
+}
+
+func ValidateCredits(v *validator.Validator, credits []Credit) {
+	v.Check(len(credits) <= 500, "credits", "must not contain more than 500 credits")
+	seen := make(map[string]bool, len(credits))
+	for i, credit := range credits {
+		key := fmt.Sprintf("credits[%d]", i)
+		v.Check(credit.PersonID > 0, key+".person_id", "must be provided")
+		v.Check(validator.In(credit.Role, RoleDirector, RoleWriter, RoleActor), key+".role", "must be director, writer or actor")
+		v.Check(len(credit.Character) <= 500, key+".character", "must not be more than 500 bytes long")
+		v.Check(credit.Character == "" || credit.Role == RoleActor, key+".character", "must only be given for actors")
+		v.Check(credit.BillingOrder >= 0, key+".billing_order", "must not be negative")
+		pair := fmt.Sprintf("%d:%s", credit.PersonID, credit.Role)
+		v.Check(!seen[pair], key, "must not repeat a person in the same role")
+		seen[pair] = true
+	}
+}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie() returns the credits of a movie in billing order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT people.id, people.name, movies_people.role, movies_people.character, movies_people.billing_order
+		FROM movies_people
+		INNER JOIN people ON people.id = movies_people.person_id
+		WHERE movies_people.movie_id = $1
+		ORDER BY movies_people.billing_order, movies_people.role, people.name, people.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(&credit.PersonID, &credit.Name, &credit.Role, &credit.Character, &credit.BillingOrder)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// GetAllForMovies() returns the credits of several movies at once, in billing order
+// and keyed by movie ID, for embedding in a page of movies. Movies without any
+// credits get an empty slice.
+func (m CreditModel) GetAllForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	query := `
+		SELECT movies_people.movie_id, people.id, people.name, movies_people.role, movies_people.character, movies_people.billing_order
+		FROM movies_people
+		INNER JOIN people ON people.id = movies_people.person_id
+		WHERE movies_people.movie_id = ANY($1)
+		ORDER BY movies_people.billing_order, movies_people.role, people.name, people.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit, len(movieIDs))
+	for _, id := range movieIDs {
+		credits[id] = []*Credit{}
+	}
+	for rows.Next() {
+		var movieID int64
+		var credit Credit
+		err := rows.Scan(&movieID, &credit.PersonID, &credit.Name, &credit.Role, &credit.Character, &credit.BillingOrder)
+		if err != nil {
+			return nil, err
+		}
+		credits[movieID] = append(credits[movieID], &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// ReplaceForMovie() swaps the credits of a movie for a new set, in one transaction.
+// If any of the credits refer to a person who doesn't exist, nothing is changed and we
+// return ErrUnknownPerson.
+func (m CreditModel) ReplaceForMovie(movieID int64, credits []Credit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies_people WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	if len(credits) > 0 {
+		args := []interface{}{movieID}
+		values := make([]string, len(credits))
+		for i, credit := range credits {
+			n := len(args)
+			values[i] = fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+			args = append(args, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder)
+		}
+		query := `
+			INSERT INTO movies_people (movie_id, person_id, role, character, billing_order)
+			VALUES ` + strings.Join(values, ", ")
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "movies_people" violates foreign key constraint "movies_people_person_id_fkey"`:
+				return ErrUnknownPerson
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
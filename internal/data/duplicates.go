@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FindDuplicate() looks for a live movie which is likely to be the same as movie: one
+// from the same year with a title at least threshold similar to its title, where 1
+// means the titles only differ in case and punctuation. The most similar one is
+// returned, or ErrRecordNotFound if there isn't one.
+func (m MovieModel) FindDuplicate(movie *Movie, threshold float64) (*Movie, error) {
+	// similarity() compares the trigrams of the titles, which leaves out case and
+	// punctuation, so "Black Panther" and "black panther!" are an exact match.
+	query := `
+		SELECT id
+		FROM movies
+		WHERE deleted_at IS NULL AND year = $2 AND similarity(title, $1) >= $3
+		ORDER BY similarity(title, $1) DESC, id
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, movie.Title, movie.Year, threshold).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return m.Get(id)
+}
+
+// FindDuplicates() is FindDuplicate() for a batch of movies, such as an import, with
+// one query however many movies there are. It returns the ID of the most similar live
+// movie for each of the movies, in the same order, with 0 for the ones that don't have
+// one.
+func (m MovieModel) FindDuplicates(movies []*Movie, threshold float64) ([]int64, error) {
+	if len(movies) == 0 {
+		return nil, nil
+	}
+	titles, years := make([]string, len(movies)), make([]int32, len(movies))
+	for i, movie := range movies {
+		titles[i], years[i] = movie.Title, movie.Year
+	}
+	query := `
+		SELECT candidates.n, (
+			SELECT id
+			FROM movies
+			WHERE deleted_at IS NULL AND year = candidates.year AND similarity(title, candidates.title) >= $3
+			ORDER BY similarity(title, candidates.title) DESC, id
+			LIMIT 1
+		)
+		FROM unnest($1::text[], $2::integer[]) WITH ORDINALITY AS candidates(title, year, n)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(titles), pq.Array(years), threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, len(movies))
+	for rows.Next() {
+		var (
+			n  int
+			id sql.NullInt64
+		)
+		err := rows.Scan(&n, &id)
+		if err != nil {
+			return nil, err
+		}
+		ids[n-1] = id.Int64
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Merge() folds the duplicate movie into the canonical one, in a single transaction.
+// The canonical movie keeps its own fields, and takes over the duplicate's credits,
+// reviews, list entries and alternate titles where it doesn't have the same ones
+// already (a user's own review of the canonical movie wins over their review of the
+// duplicate). It takes the duplicate's poster too, if it has none. The duplicate is
+// then deleted, along with anything that wasn't moved, and its ID redirects to the
+// canonical movie (see GetRedirect()).
+//
+// Merge() returns the poster that's no longer used by either movie, if any, so that
+// the caller can delete its files. If either movie doesn't exist (or is in the trash),
+// we return ErrRecordNotFound.
+func (m MovieModel) Merge(canonicalID, duplicateID int64) (Poster, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Lock both movies, in ID order so that two merges can't deadlock.
+	query := `
+		SELECT id, poster FROM movies
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE`
+	rows, err := tx.QueryContext(ctx, query, pq.Array([]int64{canonicalID, duplicateID}))
+	if err != nil {
+		return "", err
+	}
+	posters := make(map[int64]Poster, 2)
+	for rows.Next() {
+		var id int64
+		var poster Poster
+		err := rows.Scan(&id, &poster)
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		posters[id] = poster
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return "", err
+	}
+	if len(posters) != 2 {
+		return "", ErrRecordNotFound
+	}
+
+	// Each of these moves what it can from the duplicate ($2) to the canonical movie
+	// ($1). Whatever is left behind goes when the duplicate is deleted.
+	queries := []string{
+		`INSERT INTO movies_people (movie_id, person_id, role, character, billing_order)
+		SELECT $1, person_id, role, character, billing_order FROM movies_people WHERE movie_id = $2
+		ON CONFLICT DO NOTHING`,
+		`UPDATE reviews SET movie_id = $1
+		WHERE movie_id = $2 AND user_id NOT IN (SELECT user_id FROM reviews WHERE movie_id = $1)`,
+		`UPDATE list_entries SET movie_id = $1
+		WHERE movie_id = $2 AND list_id NOT IN (SELECT list_id FROM list_entries WHERE movie_id = $1)`,
+		`INSERT INTO movie_titles (movie_id, locale, title)
+		SELECT $1, locale, title FROM movie_titles WHERE movie_id = $2
+		ON CONFLICT DO NOTHING`,
+		`UPDATE movie_redirects SET to_id = $1 WHERE to_id = $2`,
+		`INSERT INTO movie_redirects (from_id, to_id) VALUES ($2, $1)`,
+	}
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, canonicalID, duplicateID)
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, duplicateID)
+	if err != nil {
+		return "", err
+	}
+
+	unused := posters[duplicateID]
+	if posters[canonicalID] == "" {
+		_, err = tx.ExecContext(ctx, `UPDATE movies SET poster = $2 WHERE id = $1`, canonicalID, unused)
+		if err != nil {
+			return "", err
+		}
+		unused = ""
+	}
+
+	// The titles and reviews have changed, so rebuild the search vector and the
+	// ratings, which moves on updated_at too.
+	query = `UPDATE movies SET search_vector = ` + searchVector("$2::regconfig", "movies.title", "movies.id") + ` WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, canonicalID, m.SearchConfig)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, updateRatingsQuery, canonicalID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return unused, nil
+}
+
+// GetRedirect() returns the ID of the movie that the movie with the given ID was
+// merged into, or ErrRecordNotFound if it wasn't merged.
+func (m MovieModel) GetRedirect(id int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var to int64
+	err := m.DB.QueryRowContext(ctx, `SELECT to_id FROM movie_redirects WHERE from_id = $1`, id).Scan(&to)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return to, nil
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FacetSafelist holds the facets that clients can ask for when listing movies.
+var FacetSafelist = []string{"genres", "decade"}
+
+// FacetCount is the number of matching movies with one value of a facet, such as the
+// "drama" genre or the "1990s" decade.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the counts for each facet that was asked for, keyed by facet name.
+type Facets map[string][]FacetCount
+
+// facetQueries holds the query for each facet in FacetSafelist. Each one is given the
+// WHERE clause for the listing criteria and returns value and count pairs.
+var facetQueries = map[string]string{
+	"genres": `
+	SELECT genre, count(*)
+	FROM movies, unnest(genres) AS genre
+	WHERE %s
+	GROUP BY genre
+	ORDER BY count(*) DESC, genre`,
+	"decade": `
+	SELECT (year / 10 * 10)::text || 's', count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY year / 10
+	ORDER BY year / 10`,
+}
+
+// Facets() counts the movies matching the listing criteria by each of the facets
+// named, ignoring pagination. The names must come from FacetSafelist.
+func (m MovieModel) Facets(criteria MovieFilters, names []string) (Facets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	facets := make(Facets, len(names))
+	for _, name := range names {
+		query, ok := facetQueries[name]
+		if !ok {
+			panic("unknown facet: " + name)
+		}
+
+		args := []interface{}{}
+		arg := func(value interface{}) string {
+			args = append(args, value)
+			return fmt.Sprintf("$%d", len(args))
+		}
+		conditions, _ := criteria.conditions(m.SearchConfig, arg)
+		query = fmt.Sprintf(query, strings.Join(conditions, "\n\tAND "))
+
+		rows, err := m.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		counts := []FacetCount{}
+		for rows.Next() {
+			var count FacetCount
+			err := rows.Scan(&count.Value, &count.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts = append(counts, count)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		facets[name] = counts
+	}
+	return facets, nil
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// MovieFieldSafelist holds the movie fields that clients can ask for with the fields
+// query string parameter, by their JSON names. Asking for title brings original_title
+// and title_locale along with it, since they only appear when the title is localized.
+var MovieFieldSafelist = []string{
+	"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count",
+	"titles", "poster_url", "title_highlight", "deleted_at",
+}
+
+// MovieFields lists the fields of the movies to fetch, by their JSON names. A nil
+// MovieFields means all of them.
+//
+// Only the columns in movieOptionalColumns (and the search headline) are actually left
+// out of the SELECT. The ID, version, ratings, poster and alternate titles are always
+// read, as they're small and we need them for the movie's ETag and for localizing its
+// title, so it's up to the handlers to leave them out of the response.
+type MovieFields []string
+
+// movieOptionalColumns are the columns that are only selected when their field is
+// asked for, or when they're needed anyway for sorting.
+var movieOptionalColumns = []string{"title", "year", "runtime", "genres"}
+
+// Has() reports whether the named field was asked for.
+func (f MovieFields) Has(name string) bool {
+	return f == nil || slices.Contains(f, name)
+}
+
+// The columns() helper returns the optional columns to select: the ones for the fields
+// that were asked for, plus any of the needed columns.
+func (f MovieFields) columns(needed ...string) []string {
+	var columns []string
+	for _, column := range movieOptionalColumns {
+		if f.Has(column) || slices.Contains(needed, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// The selectColumns() helper formats optional columns for appending to the end of a
+// SELECT list.
+func selectColumns(columns []string) string {
+	s := ""
+	for _, column := range columns {
+		s += ", " + column
+	}
+	return s
+}
+
+// The scanColumns() helper appends the destinations in movie for the optional columns
+// to dest, in the same order, for passing to Scan().
+func scanColumns(dest []interface{}, movie *Movie, columns []string) []interface{} {
+	for _, column := range columns {
+		switch column {
+		case "title":
+			dest = append(dest, &movie.Title)
+		case "year":
+			dest = append(dest, &movie.Year)
+		case "runtime":
+			dest = append(dest, &movie.Runtime)
+		case "genres":
+			dest = append(dest, pq.Array(&movie.Genres))
+		default:
+			panic("unknown movie column: " + column)
+		}
+	}
+	return dest
+}
@@ -1,39 +1,114 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"uwDavid/moviedb/internal/validator"
 )
 
 // we can embed this struct in input structs
 // so we can re-use this struct
 type Filters struct {
-	Page         int
-	PageSize     int
+	Page     int
+	PageSize int
+	// Sort is a comma-separated list of sort keys from SortSafelist, such as
+	// "-year,title". Each key is a column name, prefixed with a hyphen for a
+	// descending sort.
 	Sort         string
 	SortSafelist []string
+	// Cursor holds the opaque keyset cursor sent by the client. When it is set, the
+	// page is located by the cursor position instead of by Page.
+	Cursor string
 }
 
-// Check that the client-provided Sort field matches one of the entries in our safelist
-// and if it does, extract the column name from the Sort field by stripping the leading
-// hyphen character (if one exists).
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+// maxSortKeys is the most columns that a client can sort on at once.
+const maxSortKeys = 5
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of the opaque pagination cursor that we hand out to
+// clients. It records the sort it was issued for, the values of the sort keys (see
+// sortKeys()) for the row it points at, and whether it pages backwards from that row.
+// The values are kept as strings and left to PostgreSQL to convert to the column
+// types. Cursors aren't signed, so a client can edit one; ValidateFilters() checks
+// that each value fits its column (see validCursorValue()), so that an edited cursor
+// gets a validation error rather than a failed query.
+type cursor struct {
+	Sort     string   `json:"s"`
+	Values   []string `json:"v"`
+	Backward bool     `json:"b,omitempty"`
+}
+
+// The encodeCursor() helper turns a cursor into the URL-safe string that clients
+// send back in the cursor query string parameter.
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// The decodeCursor() helper reverses encodeCursor(), returning errInvalidCursor if the
+// value isn't a cursor at all. It doesn't check the values in the cursor, which is up
+// to ValidateFilters().
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errInvalidCursor
+	}
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort == "" || len(c.Values) == 0 {
+		return cursor{}, errInvalidCursor
+	}
+	return c, nil
+}
+
+// sortKey is a single column of the sort order, with its direction ("ASC" or "DESC").
+type sortKey struct {
+	column    string
+	direction string
+}
+
+// sortKeys() splits the Sort field into its keys, checking that each one matches an
+// entry in our safelist and stripping the leading hyphen character (if one exists) to
+// get the column name. Unless the client sorted on id already, an ascending id key
+// is added at the end so that the order is always deterministic.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	hasID := false
+	for _, value := range strings.Split(f.Sort, ",") {
+		if !validator.In(value, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + f.Sort)
+		}
+		key := sortKey{column: strings.TrimPrefix(value, "-"), direction: "ASC"}
+		if strings.HasPrefix(value, "-") {
+			key.direction = "DESC"
 		}
+		hasID = hasID || key.column == "id"
+		keys = append(keys, key)
+	}
+	if !hasID {
+		keys = append(keys, sortKey{column: "id", direction: "ASC"})
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return keys
 }
 
-// Return the sort direction ("ASC" or "DESC") depending on the prefix character of the
-// Sort field.
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// orderBy() returns the ORDER BY clause for the sort keys.
+func (f Filters) orderBy() string {
+	var clauses []string
+	for _, key := range f.sortKeys() {
+		clauses = append(clauses, key.column+" "+key.direction)
 	}
-	return "ASC"
+	return strings.Join(clauses, ", ")
 }
 
 func (f Filters) limit() int {
@@ -44,14 +119,173 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// cursor() returns the decoded client cursor, and false if the request is using
+// page-number pagination. The cursor is checked by ValidateFilters() first, so an
+// invalid cursor at this point is a logic error and we panic.
+func (f Filters) cursor() (cursor, bool) {
+	if f.Cursor == "" {
+		return cursor{}, false
+	}
+	c, err := decodeCursor(f.Cursor)
+	if err != nil {
+		panic("unsafe cursor parameter: " + f.Cursor)
+	}
+	return c, true
+}
+
+// cursorFor() returns a forward cursor pointing at a row, using the value() function
+// to look up the row's value for each sort column.
+func (f Filters) cursorFor(value func(column string) string) cursor {
+	c := cursor{Sort: f.Sort}
+	for _, key := range f.sortKeys() {
+		c.Values = append(c.Values, value(key.column))
+	}
+	return c
+}
+
+// keysetCondition() returns a SQL condition selecting the rows which come after the
+// cursor position in the current sort order (or before it, for a backwards cursor),
+// using arg() to add the cursor values to the query arguments. We can't use a row
+// comparison here because the sort keys may be sorted in different directions, so
+// for the keys k1, k2, k3 we generate:
+//
+//	k1 > $1 OR (k1 = $1 AND k2 > $2) OR (k1 = $1 AND k2 = $2 AND k3 > $3)
+func (f Filters) keysetCondition(c cursor, arg func(interface{}) string) string {
+	var (
+		equal    []string
+		branches []string
+	)
+	for i, key := range f.sortKeys() {
+		op := ">"
+		if key.direction == "DESC" {
+			op = "<"
+		}
+		if c.Backward {
+			op = flipComparison(op)
+		}
+		placeholder := arg(c.Values[i])
+		branch := append(append([]string{}, equal...), fmt.Sprintf("%s %s %s", key.column, op, placeholder))
+		branches = append(branches, "("+strings.Join(branch, " AND ")+")")
+		equal = append(equal, fmt.Sprintf("%s = %s", key.column, placeholder))
+	}
+	return "(" + strings.Join(branches, " OR ") + ")"
+}
+
+// keysetOrder() returns the ORDER BY clause for a cursor query. Backwards cursors read
+// the rows in reverse and the caller flips the results back into the normal order.
+func (f Filters) keysetOrder(c cursor) string {
+	if !c.Backward {
+		return f.orderBy()
+	}
+	var clauses []string
+	for _, key := range f.sortKeys() {
+		clauses = append(clauses, key.column+" "+flipDirection(key.direction))
+	}
+	return strings.Join(clauses, ", ")
+}
+
+func flipComparison(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+func flipDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	// Check that every sort key matches a value in the safelist, and that no column
+	// is sorted on twice.
+	values := strings.Split(f.Sort, ",")
+	columns := make([]string, len(values))
+	for i, value := range values {
+		v.Check(validator.In(value, f.SortSafelist...), "sort", "invalid sort value "+strconv.Quote(value))
+		columns[i] = strings.TrimPrefix(value, "-")
+	}
+	v.Check(validator.Unique(columns), "sort", "must not contain the same column more than once")
+	v.Check(len(values) <= maxSortKeys, "sort", fmt.Sprintf("must not contain more than %d columns", maxSortKeys))
+	// A cursor only makes sense with the sort it was issued for, and it must have a
+	// value that fits the column for each of the sort keys.
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor value")
+		} else {
+			v.Check(c.Sort == f.Sort, "cursor", "does not match the sort parameter")
+			// There is a cursor value for each sort key, including the id tie-breaker.
+			if !validator.In("id", columns...) {
+				columns = append(columns, "id")
+			}
+			valid := len(c.Values) == len(columns)
+			for i := 0; valid && i < len(columns); i++ {
+				valid = validCursorValue(columns[i], c.Values[i])
+			}
+			v.Check(valid, "cursor", "invalid cursor value")
+		}
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+	}
+}
+
+// cursorNumberRX matches the decimal numbers that we format numeric sort values as.
+var cursorNumberRX = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// The validCursorValue() helper reports whether a cursor value can be compared with
+// the sort column, by checking that it's in the form we format that column's values in
+// (see Movie.sortValue()). Text columns take anything but a NUL character, which
+// PostgreSQL doesn't allow in text.
+func validCursorValue(column, value string) bool {
+	switch column {
+	case "id":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "year", "runtime", "version", "rating", "rating_count", "birth_year":
+		_, err := strconv.ParseInt(value, 10, 32)
+		return err == nil
+	case "average_rating", "relevance":
+		return cursorNumberRX.MatchString(value)
+	case "created_at", "updated_at", "deleted_at":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	}
+	return !strings.ContainsRune(value, 0)
+}
+
+// Range holds an inclusive range filter, read from a pair of <key>_min and <key>_max
+// query string parameters. A zero Min or Max leaves that end of the range open.
+type Range struct {
+	Min int
+	Max int
+}
+
+// conditions() returns the SQL conditions restricting column to the range, using
+// arg() to add the bounds to the query arguments.
+func (r Range) conditions(column string, arg func(interface{}) string) []string {
+	var conditions []string
+	if r.Min != 0 {
+		conditions = append(conditions, fmt.Sprintf("%s >= %s", column, arg(r.Min)))
+	}
+	if r.Max != 0 {
+		conditions = append(conditions, fmt.Sprintf("%s <= %s", column, arg(r.Max)))
+	}
+	return conditions
+}
+
+// ValidateRange() checks that both ends of a range are positive and in order, using
+// the query string parameter names for the error keys.
+func ValidateRange(v *validator.Validator, key string, r Range) {
+	v.Check(r.Min >= 0, key+"_min", "must not be negative")
+	v.Check(r.Max >= 0, key+"_max", "must not be negative")
+	v.Check(r.Min == 0 || r.Max == 0 || r.Min <= r.Max, key+"_max", "must not be less than "+key+"_min")
 }
 
 // To include metadata
@@ -62,6 +296,13 @@ type Metadata struct {
 	FirstPage    int `json:"first_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	// Opaque keyset cursors for the neighbouring pages. Clients pass these back in the
+	// cursor query string parameter.
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// DidYouMean suggests similar titles when a title filter or search query matched
+	// nothing.
+	DidYouMean []string `json:"did_you_mean,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -82,3 +323,21 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// The calculateCursorMetadata() function works out the pagination metadata for a page
+// fetched with a cursor. first and last are the cursors for the first and last rows
+// of the page (in display order), and hasMore reports whether the query found a row
+// beyond the page in the direction we were reading.
+func calculateCursorMetadata(pageSize int, c cursor, hasMore bool, first, last cursor) Metadata {
+	metadata := Metadata{PageSize: pageSize}
+	// Following a cursor forwards means that there are rows before this page, and
+	// following one backwards means that there are rows after it.
+	if hasMore || c.Backward {
+		metadata.NextCursor = encodeCursor(last)
+	}
+	if hasMore || !c.Backward {
+		first.Backward = true
+		metadata.PrevCursor = encodeCursor(first)
+	}
+	return metadata
+}
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+	"uwDavid/moviedb/internal/validator"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []cursor{
+		{Sort: "id", Values: []string{"1"}},
+		{Sort: "-year,title", Values: []string{"2018", "Black Panther", "7"}},
+		{Sort: "title", Values: []string{`"Quoted", with commas & ünïcode`, "12"}, Backward: true},
+		{Sort: "-relevance", Values: []string{"0.060793", "3"}},
+	}
+
+	for _, c := range tests {
+		t.Run(c.Sort, func(t *testing.T) {
+			got, err := decodeCursor(encodeCursor(c))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c) {
+				t.Errorf("got %+v; want %+v", got, c)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"id","v":["1"]}`))},
+		{"not JSON", encode("hello")},
+		{"no sort", encode(`{"v":["1"]}`)},
+		{"no values", encode(`{"s":"id"}`)},
+		{"values of the wrong type", encode(`{"s":"id","v":[1]}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.input)
+			if err != errInvalidCursor {
+				t.Errorf("got error %v; want %v", err, errInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		sort     string
+		backward bool
+		want     string
+	}{
+		{
+			name: "ascending id",
+			sort: "id",
+			want: "((id > $1))",
+		},
+		{
+			name:     "descending id backward",
+			sort:     "-id",
+			backward: true,
+			want:     "((id > $1))",
+		},
+		{
+			name: "mixed directions",
+			sort: "-year,title",
+			want: "((year < $1) OR (year = $1 AND title > $2) OR (year = $1 AND title = $2 AND id > $3))",
+		},
+		{
+			name:     "mixed directions backward",
+			sort:     "-year,title",
+			backward: true,
+			want:     "((year > $1) OR (year = $1 AND title < $2) OR (year = $1 AND title = $2 AND id < $3))",
+		},
+		{
+			name:     "descending id in the middle backward",
+			sort:     "runtime,-id",
+			backward: true,
+			want:     "((runtime < $1) OR (runtime = $1 AND id > $2))",
+		},
+	}
+
+	safelist := []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafelist: safelist}
+			var args []interface{}
+			arg := func(value interface{}) string {
+				args = append(args, value)
+				return fmt.Sprintf("$%d", len(args))
+			}
+			c := f.cursorFor(func(column string) string { return "v-" + column })
+			c.Backward = tt.backward
+
+			got := f.keysetCondition(c, arg)
+			if got != tt.want {
+				t.Errorf("got condition\n\t%s\nwant\n\t%s", got, tt.want)
+			}
+			if len(args) != len(c.Values) {
+				t.Fatalf("got %d arguments; want %d", len(args), len(c.Values))
+			}
+			for i, value := range c.Values {
+				if args[i] != value {
+					t.Errorf("got argument %d = %v; want %v", i+1, args[i], value)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	safelist := []string{"id", "title", "year", "-id", "-title", "-year", "-average_rating", "-relevance", "-deleted_at"}
+	tests := []struct {
+		name  string
+		sort  string
+		c     cursor
+		valid bool
+	}{
+		{"issued cursor", "-year,title", cursor{Sort: "-year,title", Values: []string{"2018", "Black Panther", "7"}}, true},
+		{"backward cursor", "id", cursor{Sort: "id", Values: []string{"7"}, Backward: true}, true},
+		{"numeric values", "-average_rating", cursor{Sort: "-average_rating", Values: []string{"4.50", "7"}}, true},
+		{"relevance", "-relevance", cursor{Sort: "-relevance", Values: []string{"0.060793", "7"}}, true},
+		{"timestamp", "-deleted_at", cursor{Sort: "-deleted_at", Values: []string{"2024-01-02T03:04:05.123456Z", "7"}}, true},
+		{"other sort", "id", cursor{Sort: "year", Values: []string{"2018", "7"}}, false},
+		{"too few values", "year", cursor{Sort: "year", Values: []string{"2018"}}, false},
+		{"too many values", "id", cursor{Sort: "id", Values: []string{"1", "2"}}, false},
+		{"text for an integer", "year", cursor{Sort: "year", Values: []string{"abc", "1"}}, false},
+		{"integer out of range", "year", cursor{Sort: "year", Values: []string{"99999999999", "1"}}, false},
+		{"text for the id", "title", cursor{Sort: "title", Values: []string{"Up", "one"}}, false},
+		{"hex for a number", "-average_rating", cursor{Sort: "-average_rating", Values: []string{"0x1p-2", "7"}}, false},
+		{"NaN for a number", "-relevance", cursor{Sort: "-relevance", Values: []string{"NaN", "7"}}, false},
+		{"bad timestamp", "-deleted_at", cursor{Sort: "-deleted_at", Values: []string{"yesterday", "7"}}, false},
+		{"NUL in text", "title", cursor{Sort: "title", Values: []string{"Up\x00", "7"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: safelist, Cursor: encodeCursor(tt.c)})
+			if v.Valid() != tt.valid {
+				t.Errorf("got errors %v; want valid: %t", v.Errors, tt.valid)
+			}
+		})
+	}
+}
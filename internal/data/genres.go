@@ -0,0 +1,346 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateAlias is returned when a genre slug or alias is already used by
+	// another genre.
+	ErrDuplicateAlias = errors.New("duplicate genre alias")
+	// ErrGenreInUse is returned when deleting a genre that movies still have.
+	ErrGenreInUse = errors.New("genre in use")
+)
+
+// Genre is an entry in the genre catalogue. Movies store the slugs of their genres,
+// and the aliases are the other spellings that we accept for the genre and turn into
+// its slug. MovieCount is only filled in by GetAll().
+type Genre struct {
+	ID         int64    `json:"id"`
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases"`
+	MovieCount int      `json:"movie_count"`
+	Version    int32    `json:"version"`
+}
+
+// Slugify() turns a genre name into its canonical form: lower case, with each run of
+// characters other than letters and digits replaced by a single hyphen. So "Sci-Fi",
+// "sci fi" and " SCI_FI " all become "sci-fi". The migration which created the
+// catalogue does the same thing in SQL.
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(genre.Slug == Slugify(genre.Slug), "slug", "must only contain lower case letters, digits and single hyphens")
+	v.Check(len(genre.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+	for _, alias := range genre.Aliases {
+		v.Check(Slugify(alias) != "", "aliases", "must not contain empty aliases")
+		v.Check(Slugify(alias) != genre.Slug, "aliases", "must not contain the slug")
+	}
+}
+
+// GenreCatalogue maps every accepted genre alias (and slug) to its genre's slug.
+type GenreCatalogue map[string]string
+
+// ValidateMovieGenres() checks the genres of a movie against the catalogue, and replaces
+// them with their slugs. It should be called after ValidateMovie(), since two
+// spellings of the same genre are only found to be duplicates once they're replaced.
+func ValidateMovieGenres(v *validator.Validator, movie *Movie, catalogue GenreCatalogue) {
+	slugs := make([]string, 0, len(movie.Genres))
+	for _, genre := range movie.Genres {
+		slug, ok := catalogue[Slugify(genre)]
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("contains unknown genre %q", genre))
+			continue
+		}
+		slugs = append(slugs, slug)
+	}
+	v.Check(validator.Unique(slugs), "genres", "must not contain the same genre more than once")
+	if movie.Genres != nil {
+		movie.Genres = slugs
+	}
+}
+
+// genreSlugs() returns a SQL expression which turns the text array in placeholder into
+// the matching genre slugs, for filtering. Names that aren't in the catalogue are
+// slugified and kept, so that they simply match nothing.
+func genreSlugs(placeholder string) string {
+	return fmt.Sprintf(`ARRAY(
+		SELECT COALESCE(genres.slug, used.alias)
+		FROM unnest(%s::text[]) WITH ORDINALITY AS used(alias, ord)
+		LEFT JOIN genre_aliases ON genre_aliases.alias = used.alias
+		LEFT JOIN genres ON genres.id = genre_aliases.genre_id
+		ORDER BY used.ord)`, placeholder)
+}
+
+// slugifyAll() slugifies each of a list of genre names.
+func slugifyAll(names []string) []string {
+	slugs := make([]string, len(names))
+	for i, name := range names {
+		slugs[i] = Slugify(name)
+	}
+	return slugs
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Catalogue() loads every alias in the catalogue.
+func (m GenreModel) Catalogue() (GenreCatalogue, error) {
+	query := `
+		SELECT genre_aliases.alias, genres.slug
+		FROM genre_aliases
+		INNER JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalogue := make(GenreCatalogue)
+	for rows.Next() {
+		var alias, slug string
+		err := rows.Scan(&alias, &slug)
+		if err != nil {
+			return nil, err
+		}
+		catalogue[alias] = slug
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return catalogue, nil
+}
+
+// GetAll() returns the whole catalogue in name order, with the number of movies (not
+// counting the trash) that have each genre.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT genres.id, genres.slug, genres.name, genres.version,
+			ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id AND alias <> genres.slug ORDER BY alias),
+			(SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+		FROM genres
+		ORDER BY genres.name, genres.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(
+			&genre.ID,
+			&genre.Slug,
+			&genre.Name,
+			&genre.Version,
+			pq.Array(&genre.Aliases),
+			&genre.MovieCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, slug, name, version,
+			ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id AND alias <> genres.slug ORDER BY alias)
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.Slug,
+		&genre.Name,
+		&genre.Version,
+		pq.Array(&genre.Aliases),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+// Insert() adds a genre along with its aliases. If the slug or any of the aliases
+// already belong to a genre, we return ErrDuplicateAlias.
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO genres (slug, name)
+		VALUES ($1, $2)
+		RETURNING id, version`
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateAlias
+		default:
+			return err
+		}
+	}
+
+	err = m.insertAliases(ctx, tx, genre.ID, append([]string{genre.Slug}, genre.Aliases...))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update() saves a genre's name and replaces its aliases, using the version number to
+// catch edit conflicts. The slug can't be changed, because movies refer to it.
+func (m GenreModel) Update(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE genres
+		SET name = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ID, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflit
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1 AND alias <> $2`, genre.ID, genre.Slug)
+	if err != nil {
+		return err
+	}
+	err = m.insertAliases(ctx, tx, genre.ID, genre.Aliases)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The insertAliases() helper adds aliases for a genre, slugified so that they match
+// what ValidateMovieGenres() looks up.
+func (m GenreModel) insertAliases(ctx context.Context, tx *sql.Tx, genreID int64, aliases []string) error {
+	query := `
+		INSERT INTO genre_aliases (alias, genre_id)
+		SELECT DISTINCT alias, $1 FROM unnest($2::text[]) AS alias`
+	_, err := tx.ExecContext(ctx, query, genreID, pq.Array(slugifyAll(aliases)))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genre_aliases_pkey"`:
+			return ErrDuplicateAlias
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a genre from the catalogue, as long as no movies (including those
+// in the trash) have it. Otherwise we return ErrGenreInUse.
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		WITH genre AS (
+			SELECT id, slug FROM genres WHERE id = $1
+		), deleted AS (
+			DELETE FROM genres
+			WHERE id = $1 AND NOT EXISTS (
+				SELECT 1 FROM movies, genre WHERE movies.genres @> ARRAY[genre.slug]
+			)
+			RETURNING id
+		)
+		SELECT EXISTS (SELECT 1 FROM genre), EXISTS (SELECT 1 FROM deleted)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var found, deleted bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&found, &deleted)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !found:
+		return ErrRecordNotFound
+	case !deleted:
+		return ErrGenreInUse
+	}
+	return nil
+}
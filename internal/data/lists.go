@@ -0,0 +1,375 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// WatchlistName is the name of the list that every user gets by default.
+const WatchlistName = "watchlist"
+
+var (
+	// ErrDuplicateEntry is returned when a movie is added to a list it's already on.
+	ErrDuplicateEntry = errors.New("duplicate list entry")
+	// ErrEntriesMismatch is returned when a new order for a list doesn't contain
+	// exactly the movies that are on it.
+	ErrEntriesMismatch = errors.New("list entries mismatch")
+)
+
+// List is a user's ordered list of movies. Each user has a watchlist, and can make as
+// many custom lists as they like. Private lists are only visible to their owner.
+type List struct {
+	ID         int64        `json:"id"`
+	UserID     int64        `json:"user_id"`
+	Name       string       `json:"name"`
+	Public     bool         `json:"public"`
+	Watchlist  bool         `json:"watchlist"`
+	EntryCount int          `json:"entry_count"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Version    int32        `json:"version"`
+	Entries    []*ListEntry `json:"entries,omitempty"`
+}
+
+// ListEntry is a movie on a list, at a position. Positions put the entries in order,
+// but they may have gaps.
+type ListEntry struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(list.Watchlist == (list.Name == WatchlistName), "name", "must not be changed for the watchlist, or used for other lists")
+}
+
+type ListModel struct {
+	DB *sql.DB
+}
+
+// EnsureWatchlist() creates the user's watchlist, if they don't have it yet.
+func (m ListModel) EnsureWatchlist(userID int64) error {
+	query := `
+		INSERT INTO lists (user_id, name, watchlist)
+		VALUES ($1, $2, true)
+		ON CONFLICT (user_id) WHERE watchlist DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, WatchlistName)
+	return err
+}
+
+func (m ListModel) Insert(list *List) error {
+	query := `
+		INSERT INTO lists (user_id, name, public)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, list.UserID, list.Name, list.Public).Scan(&list.ID, &list.CreatedAt, &list.Version)
+}
+
+// Get() returns a list without its entries (see GetEntries()). Like GetEntries(), the
+// entry count leaves out movies in the trash.
+func (m ListModel) Get(id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, user_id, name, public, watchlist, created_at, version,
+			(SELECT count(*) FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+				WHERE list_entries.list_id = lists.id AND movies.deleted_at IS NULL)
+		FROM lists
+		WHERE id = $1`
+
+	var list List
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		&list.Public,
+		&list.Watchlist,
+		&list.CreatedAt,
+		&list.Version,
+		&list.EntryCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &list, nil
+}
+
+// GetAllForUser() returns all of a user's lists, watchlist first.
+func (m ListModel) GetAllForUser(userID int64) ([]*List, error) {
+	query := `
+		SELECT id, user_id, name, public, watchlist, created_at, version,
+			(SELECT count(*) FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+				WHERE list_entries.list_id = lists.id AND movies.deleted_at IS NULL)
+		FROM lists
+		WHERE user_id = $1
+		ORDER BY watchlist DESC, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*List{}
+	for rows.Next() {
+		var list List
+		err := rows.Scan(
+			&list.ID,
+			&list.UserID,
+			&list.Name,
+			&list.Public,
+			&list.Watchlist,
+			&list.CreatedAt,
+			&list.Version,
+			&list.EntryCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &list)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// GetEntries() returns the entries of a list in order. Movies in the trash are left
+// out until they are restored.
+func (m ListModel) GetEntries(listID int64) ([]*ListEntry, error) {
+	query := `
+		SELECT list_entries.position, list_entries.added_at,
+			movies.id, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+		FROM list_entries
+		INNER JOIN movies ON movies.id = list_entries.movie_id
+		WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY list_entries.position, list_entries.added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*ListEntry{}
+	for rows.Next() {
+		entry := ListEntry{Movie: &Movie{}}
+		err := rows.Scan(
+			&entry.Position,
+			&entry.AddedAt,
+			&entry.Movie.ID,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Update() saves a list's name and visibility, using the version number to catch edit
+// conflicts.
+func (m ListModel) Update(list *List) error {
+	query := `
+		UPDATE lists
+		SET name = $1, public = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, list.Name, list.Public, list.ID, list.Version).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflit
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete() removes a list and its entries. The watchlist can't be deleted, and is
+// treated as not found.
+func (m ListModel) Delete(id int64) error {
+	query := `
+		DELETE FROM lists
+		WHERE id = $1 AND NOT watchlist`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The withEntries() helper runs a change to the entries of a list in a transaction,
+// after locking the list row so that changes to the same list take turns.
+func (m ListModel) withEntries(listID int64, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM lists WHERE id = $1 FOR UPDATE`, listID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddEntry() puts a movie on a list at a position, moving the entries at and after it
+// down one. A position of zero (or past the end) adds the movie at the end. If the
+// movie doesn't exist we return ErrRecordNotFound, and if it's on the list already we
+// return ErrDuplicateEntry.
+func (m ListModel) AddEntry(listID, movieID int64, position int) error {
+	return m.withEntries(listID, func(ctx context.Context, tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, movieID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRecordNotFound
+		}
+
+		var end int
+		err = tx.QueryRowContext(ctx, `SELECT COALESCE(max(position), 0) + 1 FROM list_entries WHERE list_id = $1`, listID).Scan(&end)
+		if err != nil {
+			return err
+		}
+		if position < 1 || position > end {
+			position = end
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE list_entries SET position = position + 1 WHERE list_id = $1 AND position >= $2`, listID, position)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO list_entries (list_id, movie_id, position) VALUES ($1, $2, $3)`, listID, movieID, position)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "list_entries_pkey"`:
+				return ErrDuplicateEntry
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveEntry() takes a movie off a list, moving the entries after it up one.
+func (m ListModel) RemoveEntry(listID, movieID int64) error {
+	return m.withEntries(listID, func(ctx context.Context, tx *sql.Tx) error {
+		var position int
+		err := tx.QueryRowContext(ctx, `DELETE FROM list_entries WHERE list_id = $1 AND movie_id = $2 RETURNING position`, listID, movieID).Scan(&position)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE list_entries SET position = position - 1 WHERE list_id = $1 AND position > $2`, listID, position)
+		return err
+	})
+}
+
+// Reorder() puts the entries of a list in the order of movieIDs, which must contain
+// each movie on the list exactly once. Otherwise we return ErrEntriesMismatch. As in
+// GetEntries(), movies in the trash don't count, and they keep their old positions.
+func (m ListModel) Reorder(listID int64, movieIDs []int64) error {
+	return m.withEntries(listID, func(ctx context.Context, tx *sql.Tx) error {
+		// Check that the new order has the same movies as the list, by counting the
+		// entries which match a movie in it.
+		query := `
+			SELECT count(*), count(*) FILTER (WHERE list_entries.movie_id = ANY($2))
+			FROM list_entries
+			INNER JOIN movies ON movies.id = list_entries.movie_id
+			WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL`
+		var total, matched int
+		err := tx.QueryRowContext(ctx, query, listID, pq.Array(movieIDs)).Scan(&total, &matched)
+		if err != nil {
+			return err
+		}
+		if total != len(movieIDs) || matched != total {
+			return ErrEntriesMismatch
+		}
+
+		query = `
+			UPDATE list_entries
+			SET position = entries.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS entries(movie_id, position)
+			WHERE list_entries.list_id = $1 AND list_entries.movie_id = entries.movie_id`
+		_, err = tx.ExecContext(ctx, query, listID, pq.Array(movieIDs))
+		return err
+	})
+}
@@ -13,6 +13,12 @@ var (
 // Models struct to wrap MovieModel + others
 type Models struct {
 	Movies      MovieModel
+	Revisions   RevisionModel
+	People      PersonModel
+	Credits     CreditModel
+	Reviews     ReviewModel
+	Lists       ListModel
+	Genres      GenreModel
 	Permissions PermissionModel
 	Users       UserModel // Add a new Users field.
 	Tokens      TokenModel
@@ -20,7 +26,13 @@ type Models struct {
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
+		Movies:      MovieModel{DB: db, SearchConfig: DefaultSearchConfig},
+		Revisions:   RevisionModel{DB: db},
+		People:      PersonModel{DB: db},
+		Credits:     CreditModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Lists:       ListModel{DB: db},
+		Genres:      GenreModel{DB: db},
 		Permissions: PermissionModel{DB: db},
 		Users:       UserModel{DB: db}, // Initialize a new UserModel instance.
 		Tokens:      TokenModel{DB: db},
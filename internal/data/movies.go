@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 	"uwDavid/moviedb/internal/validator"
 
@@ -15,12 +17,35 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"`
+	// UpdatedAt is the time of the last change to the movie or its ratings, which we
+	// send to clients in the Last-Modified header.
+	UpdatedAt time.Time `json:"-"`
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitempty"`
 	// note the custom Runtime type
 	Runtime Runtime  `json:"runtime,omitempty"`
 	Genres  []string `json:"genres,omitempty"`
 	Version int32    `json:"version"`
+	// DeletedAt is set once the movie has been moved to the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// AverageRating and RatingCount summarize the user reviews of the movie. They are
+	// kept up to date by ReviewModel, and aren't part of the movie's version.
+	AverageRating float64 `json:"average_rating,omitempty"`
+	RatingCount   int32   `json:"rating_count,omitempty"`
+	// Titles holds the movie's alternate titles by locale. When a handler swaps Title
+	// for one of them, OriginalTitle keeps the original and TitleLocale says which
+	// locale Title is in.
+	Titles        Titles `json:"titles,omitempty"`
+	OriginalTitle string `json:"original_title,omitempty"`
+	TitleLocale   string `json:"title_locale,omitempty"`
+	// Poster names the movie's poster image in storage, if it has one. It's set with
+	// SetPoster() rather than Update(), and isn't part of the version either.
+	Poster Poster `json:"poster_url,omitempty"`
+	// Relevance is the full-text search rank of the movie, and TitleHighlight is the
+	// title with the matching words wrapped in <b> tags. Both are only populated
+	// when listing movies with a search query.
+	Relevance      float64 `json:"-"`
+	TitleHighlight string  `json:"title_highlight,omitempty"`
 }
 
 // validation is performed on a Movie struct, instead of the input struct in our handlers
@@ -31,34 +56,195 @@ type Movie struct {
 // equal to the empty string". In the second, we "check that the length of the title
 // is less than or equal to 500 bytes" and so on.
 
+// MovieFilters holds the criteria for listing movies with GetAll(). Title and Genres
+// are the original filters (matching title words, and movies having all of the
+// genres), and Query is a full-text search query.
+type MovieFilters struct {
+	Title         string
+	Query         string
+	Genres        []string
+	GenresAny     []string
+	ExcludeGenres []string
+	Year          Range
+	Runtime       Range
+	// Person selects the movies that the person with this ID is credited on.
+	Person int64
+	// Fuzzy makes the Title filter also match titles (original or alternate) which
+	// are similar to it, to allow for misspellings.
+	Fuzzy bool
+	// Trash selects the deleted movies instead of the live ones.
+	Trash bool
+}
+
+// ValidateMovieFilters() checks the movie listing criteria, using the query string
+// parameter names for the error keys.
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	v.Check(len(f.Query) <= 500, "q", "must not be more than 500 bytes long")
+	ValidateRange(v, "year", f.Year)
+	v.Check(f.Year.Min == 0 || f.Year.Min >= 1888, "year_min", "must be greater than 1888")
+	v.Check(f.Year.Max == 0 || f.Year.Max >= 1888, "year_max", "must be greater than 1888")
+	ValidateRange(v, "runtime", f.Runtime)
+	v.Check(len(f.GenresAny) <= 20, "genres_any", "must not contain more than 20 genres")
+	v.Check(len(f.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+	v.Check(f.Person >= 0, "person", "must not be negative")
+}
+
+// conditions() returns the SQL conditions for the criteria, using arg() to add their
+// values to the query arguments. searchConfig is the text search configuration for the
+// search query, which is only added to the arguments when there is one, as PostgreSQL
+// can't work out the type of an argument that the query doesn't use. If there is a
+// search query, its tsquery expression is returned too so that the caller can rank
+// and highlight the matches.
+func (f MovieFilters) conditions(searchConfig string, arg func(interface{}) string) ([]string, string) {
+	// Genre filters can use any spelling or alias of a genre, which genreSlugs()
+	// turns into the slugs stored on the movies.
+	titleArg, genresArg := arg(f.Title), arg(pq.Array(slugifyAll(f.Genres)))
+	// The title filter sticks to the 'simple' configuration, matching whole words in
+	// the original or an alternate title as it always has, whatever configuration
+	// the search query uses.
+	title := fmt.Sprintf("to_tsvector('simple', title) @@ plainto_tsquery('simple', %[1]s) OR id IN (SELECT movie_id FROM movie_titles WHERE to_tsvector('simple', movie_titles.title) @@ plainto_tsquery('simple', %[1]s))", titleArg)
+	if f.Fuzzy {
+		title = fmt.Sprintf("%s OR title %%> %s OR id IN (SELECT movie_id FROM movie_titles WHERE title %%> %s)", title, titleArg, titleArg)
+	}
+	conditions := []string{
+		"deleted_at IS NULL",
+		fmt.Sprintf("(%s OR %s = '')", title, titleArg),
+		fmt.Sprintf("(genres @> %s OR %s = '{}')", genreSlugs(genresArg), genresArg),
+	}
+	if f.Trash {
+		conditions[0] = "deleted_at IS NOT NULL"
+	}
+	if len(f.GenresAny) > 0 {
+		conditions = append(conditions, "genres && "+genreSlugs(arg(pq.Array(slugifyAll(f.GenresAny)))))
+	}
+	if len(f.ExcludeGenres) > 0 {
+		conditions = append(conditions, fmt.Sprintf("NOT (genres && %s)", genreSlugs(arg(pq.Array(slugifyAll(f.ExcludeGenres))))))
+	}
+	conditions = append(conditions, f.Year.conditions("year", arg)...)
+	conditions = append(conditions, f.Runtime.conditions("runtime", arg)...)
+	if f.Person != 0 {
+		conditions = append(conditions, "id IN (SELECT movie_id FROM movies_people WHERE person_id = "+arg(f.Person)+")")
+	}
+	if f.Query == "" {
+		return conditions, ""
+	}
+	config := arg(searchConfig) + "::regconfig"
+	websearch, prefix := parseSearchQuery(f.Query)
+	tsquery := fmt.Sprintf("websearch_to_tsquery(%s, %s)", config, arg(websearch))
+	if prefix != "" {
+		tsquery = fmt.Sprintf("(%s && to_tsquery(%s, %s))", tsquery, config, arg(prefix))
+	}
+	return append(conditions, "search_vector @@ "+tsquery), tsquery
+}
+
 type MovieModel struct {
 	DB *sql.DB
+	// SearchConfig is the text search configuration used for the search_vector column,
+	// such as "english" or "simple".
+	SearchConfig string
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert() adds a new movie, recording its first revision as the work of the user
+// with the given ID.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+		WITH movie AS (
+			INSERT INTO movies (title, year, runtime, genres, search_vector)
+			VALUES ($1, $2, $3, $4, to_tsvector($5::regconfig, $1))
+			RETURNING id, created_at, updated_at, title, year, runtime, genres, version
+		), revision AS (` + revisionInsert(RevisionInsert, "$6") + `)
+		SELECT id, created_at, updated_at, version FROM movie`
 
 	// args is a slice containing the values
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), m.SearchConfig, userID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+}
+
+// InsertMany() bulk inserts movies using COPY, which is much faster than inserting
+// them one at a time, and returns the number of movies inserted. COPY can't evaluate
+// expressions, so we copy the rows into a temporary table and then move them into
+// movies along with their search vectors, all in one transaction.
+func (m MovieModel) InsertMany(movies []*Movie, userID int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE movies_import (title text, year integer, runtime integer, genres text[])
+		ON COMMIT DROP`)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies_import", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return 0, err
+	}
+	for _, movie := range movies {
+		_, err = stmt.ExecContext(ctx, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		if err != nil {
+			stmt.Close()
+			return 0, err
+		}
+	}
+	// Calling Exec() with no arguments flushes the buffered rows to the server.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		return 0, err
+	}
+	err = stmt.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// The rows affected are the revisions, which match the movies one for one.
+	query := `
+		WITH movie AS (
+			INSERT INTO movies (title, year, runtime, genres, search_vector)
+			SELECT title, year, runtime, genres, to_tsvector($1::regconfig, title)
+			FROM movies_import
+			RETURNING id, title, year, runtime, genres, version
+		)
+		` + revisionInsert(RevisionInsert, "$2")
+	result, err := tx.ExecContext(ctx, query, m.SearchConfig, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// GetFields() is Get() for only some of the movie's fields (see MovieFields), which
+// leaves the rest of the columns out of the query.
+func (m MovieModel) GetFields(id int64, fields MovieFields) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	columns := fields.columns()
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, updated_at, version, average_rating, rating_count, poster,
+			` + titlesColumn("movies.id") + selectColumns(columns) + `
 		FROM movies
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	// declare a movie struct
@@ -68,15 +254,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	defer cancel()
 	// defer ensure context is released before Get() method returns
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	dest := []interface{}{
 		&movie.ID,
 		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
+		&movie.UpdatedAt,
 		&movie.Version,
-	)
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.Poster,
+		&movie.Titles,
+	}
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(scanColumns(dest, &movie, columns)...)
 
 	if err != nil {
 		switch {
@@ -89,12 +277,18 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update() saves the changes to a movie, as long as nobody else has changed it since
+// it was read (its version still matches), and records the new revision.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
 	query := `
-		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 and version = $6
-		RETURNING version`
+		WITH movie AS (
+			UPDATE movies
+			SET title = $1, year = $2, runtime = $3, genres = $4, search_vector = ` + searchVector("$7::regconfig", "$1", "$5") + `,
+				version = version + 1, updated_at = NOW()
+			WHERE id = $5 and version = $6 AND deleted_at IS NULL
+			RETURNING id, title, year, runtime, genres, version, updated_at
+		), revision AS (` + revisionInsert(RevisionUpdate, "$8") + `)
+		SELECT version, updated_at FROM movie`
 
 	args := []interface{}{
 		movie.Title,
@@ -103,12 +297,14 @@ func (m MovieModel) Update(movie *Movie) error {
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		m.SearchConfig,
+		userID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	// scanning the new version value into the movie struct
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	// scanning the new version value and modification time into the movie struct
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -120,14 +316,24 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete() moves a movie to the trash by setting its deleted_at time. The movie is
+// hidden from Get() and GetAll() from then on, and can be brought back with Restore()
+// until Purge() removes it for good. The deletion is recorded as a revision too.
+// If version isn't zero, the movie is only deleted if it is still at that version, and
+// we return an ErrEditConflit error if it isn't.
+func (m MovieModel) Delete(id int64, version int32, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-	DELETE FROM movies
-	WHERE id = $1`
+	WITH movie AS (
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL AND ($3 = 0 OR version = $3)
+		RETURNING id, title, year, runtime, genres, version
+	)
+	` + revisionInsert(RevisionDelete, "$2")
 	// Execute the SQL query using the Exec() method, passing in the id variable as
 	// the value for the placeholder parameter.
 	// The Exec() method returns a sql.Result object.
@@ -135,7 +341,7 @@ func (m MovieModel) Delete(id int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, userID, version)
 	if err != nil {
 		return err
 	}
@@ -145,30 +351,169 @@ func (m MovieModel) Delete(id int64) error {
 	if err != nil {
 		return err
 	}
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
+	// If no rows were affected, we know that the movies table didn't contain a live
+	// record with the provided ID at the moment we tried to delete it. In that case we
+	// return an ErrRecordNotFound error. When we were asked for a particular version,
+	// the caller has just fetched the movie, so it must have been changed (or deleted)
+	// by someone else in the meantime.
 	if rowsAffected == 0 {
+		if version != 0 {
+			return ErrEditConflit
+		}
 		return ErrRecordNotFound
 	}
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Restore() takes a movie back out of the trash and returns it. If there is no
+// deleted movie with the ID, we return an ErrRecordNotFound error.
+func (m MovieModel) Restore(id int64, userID int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		WITH movie AS (
+			UPDATE movies
+			SET deleted_at = NULL, version = version + 1, updated_at = NOW()
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING id, created_at, updated_at, title, year, runtime, genres, version, average_rating, rating_count, poster
+		), revision AS (` + revisionInsert(RevisionRestore, "$2") + `)
+		SELECT id, created_at, updated_at, title, year, runtime, genres, version, average_rating, rating_count, poster,
+			` + titlesColumn("movie.id") + `
+		FROM movie`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.Poster,
+		&movie.Titles,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// Purge() permanently deletes the movies which were moved to the trash before the
+// cutoff time, returning how many were removed along with the posters they had, so
+// that the caller can delete their files.
+func (m MovieModel) Purge(cutoff time.Time) (int64, []Poster, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < $1
+		RETURNING poster`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var n int64
+	var posters []Poster
+	for rows.Next() {
+		var poster Poster
+		err := rows.Scan(&poster)
+		if err != nil {
+			return 0, nil, err
+		}
+		n++
+		if poster != "" {
+			posters = append(posters, poster)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return n, posters, nil
+}
+
+// GetAll() lists the movies matching the criteria. A search query uses websearch
+// syntax plus prefix terms (see parseSearchQuery()), and the matches are ranked and
+// highlighted. Only the columns for the fields asked for are fetched (see MovieFields).
+func (m MovieModel) GetAll(criteria MovieFilters, filters Filters, fields MovieFields) ([]*Movie, Metadata, error) {
+	// The query is assembled from optional parts, so the arg() helper appends a value
+	// to args and hands back its placeholder.
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions, tsquery := criteria.conditions(m.SearchConfig, arg)
+	// The rank is rounded to a fixed number of decimal places, so that it survives
+	// the trip through a cursor exactly (see sortValue()).
+	relevance, headline := "0::numeric", "''"
+	if tsquery != "" {
+		relevance = fmt.Sprintf("round(ts_rank(search_vector, %s)::numeric, 6)", tsquery)
+		if fields.Has("title_highlight") {
+			headline = fmt.Sprintf("ts_headline(%s::regconfig, title, %s, 'HighlightAll=true')", arg(m.SearchConfig), tsquery)
+		}
+	}
+	// Besides the fields that were asked for, the subquery needs the sort columns for
+	// ordering and cursors, and the title for the headline.
+	var needed []string
+	for _, key := range filters.sortKeys() {
+		needed = append(needed, key.column)
+	}
+	if headline != "''" {
+		needed = append(needed, "title")
+	}
+	columns := fields.columns(needed...)
+
+	// In cursor mode the keyset condition takes the place of OFFSET, and we fetch one
+	// extra row so that we know whether there is another page beyond this one. Cursor
+	// pages don't report a total either, so we skip counting every matching row, which
+	// would undo much of the point of keyset pagination on a large table.
+	keyset, order, total := "TRUE", filters.orderBy(), "count(*) OVER()"
+	limit, offset := filters.limit(), filters.offset()
+	c, cursorMode := filters.cursor()
+	if cursorMode {
+		keyset, order, total = filters.keysetCondition(c, arg), filters.keysetOrder(c), "0"
+		limit, offset = limit+1, 0
+	}
+	// The ranking happens in a subquery so that the keyset condition and ORDER BY
+	// can refer to relevance like any other column. The headline is only worked out
+	// for the rows on the page.
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	SELECT %s, id, created_at, version, deleted_at, average_rating, rating_count, poster, %s,
+		relevance, %s%s
+	FROM (
+		SELECT id, created_at, version, deleted_at, average_rating, rating_count, poster,
+			%s AS relevance%s
+		FROM movies
+		WHERE %s
+	) AS movies
+	WHERE %s
+	ORDER BY %s
+	LIMIT %s OFFSET %s`, total, titlesColumn("movies.id"), headline, selectColumns(columns), relevance, selectColumns(columns),
+		strings.Join(conditions, "\n\t\tAND "), keyset, order, arg(limit), arg(offset))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -183,18 +528,23 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	for rows.Next() {
 		// Initialize an empty Movie struct to hold the data for an individual movie.
 		var movie Movie
-		// Scan the values from the row into the Movie struct. Again, note that we're
-		// using the pq.Array() adapter on the genres field here.
-		err := rows.Scan(
+		// Scan the values from the row into the Movie struct, followed by the optional
+		// columns. Again, note that scanColumns() uses the pq.Array() adapter on the
+		// genres field.
+		dest := []interface{}{
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
 			&movie.Version,
-		)
+			&movie.DeletedAt,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Poster,
+			&movie.Titles,
+			&movie.Relevance,
+			&movie.TitleHighlight,
+		}
+		err := rows.Scan(scanColumns(dest, &movie, columns)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -206,29 +556,155 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
-	// If everything went OK, then return the slice of movies.
+	if len(movies) == 0 {
+		return movies, Metadata{}, nil
+	}
+
+	if !cursorMode {
+		metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		// Hand out cursors in page-number mode too, so that clients can switch over
+		// to cursor pagination from any page.
+		first, last := movies[0], movies[len(movies)-1]
+		if metadata.CurrentPage < metadata.LastPage {
+			metadata.NextCursor = encodeCursor(filters.cursorFor(last.sortValue))
+		}
+		if metadata.CurrentPage > 1 {
+			prev := filters.cursorFor(first.sortValue)
+			prev.Backward = true
+			metadata.PrevCursor = encodeCursor(prev)
+		}
+		return movies, metadata, nil
+	}
+
+	// Drop the lookahead row, and put the rows of a backwards page back into the
+	// normal sort order.
+	hasMore := len(movies) > filters.PageSize
+	if hasMore {
+		movies = movies[:filters.PageSize]
+	}
+	if c.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+	first, last := movies[0], movies[len(movies)-1]
+	metadata := calculateCursorMetadata(filters.PageSize, c, hasMore, filters.cursorFor(first.sortValue), filters.cursorFor(last.sortValue))
 	return movies, metadata, nil
 }
 
+// Export() streams every movie matching the criteria to the fn callback in ID order.
+// The rows are handed over as they are read instead of being collected into a slice,
+// so that the whole table can be exported without holding it in memory. An export
+// runs for as long as the client keeps reading, so rather than a fixed timeout it
+// takes its context from the caller. Any error returned by fn stops the export.
+func (m MovieModel) Export(ctx context.Context, criteria MovieFilters, fn func(*Movie) error) error {
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions, _ := criteria.conditions(m.SearchConfig, arg)
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, version, average_rating, rating_count, poster
+	FROM movies
+	WHERE %s
+	ORDER BY id`, strings.Join(conditions, "\n\tAND "))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Poster,
+		)
+		if err != nil {
+			return err
+		}
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sortValue() returns the value of a sortable column as a string, for storing in a
+// pagination cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "deleted_at":
+		return movie.DeletedAt.Format(time.RFC3339Nano)
+	case "average_rating":
+		// average_rating is a numeric(4, 2) column, so two decimal places gives us
+		// the exact value back.
+		return strconv.FormatFloat(movie.AverageRating, 'f', 2, 64)
+	case "relevance":
+		// GetAll() rounds the rank to six decimal places, so six decimal places gives
+		// us the exact value back, as with average_rating.
+		return strconv.FormatFloat(movie.Relevance, 'f', 6, 64)
+	}
+	panic("unknown sort column: " + column)
+}
+
+// ReindexSearch() rebuilds the search vector of every movie with the current search
+// configuration, returning the number of movies that changed. It is needed after the
+// deployment switches to a different text search configuration.
+func (m MovieModel) ReindexSearch() (int64, error) {
+	vector := searchVector("$1::regconfig", "movies.title", "movies.id")
+	query := `
+		UPDATE movies
+		SET search_vector = ` + vector + `
+		WHERE search_vector IS DISTINCT FROM ` + vector
+
+	// This touches every row, so allow it much longer than a normal query.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, m.SearchConfig)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // Mock Movie Model for testing
 type MockMovieModel struct{}
 
-func (m MockMovieModel) Insert(movie *Movie) error {
+func (m MockMovieModel) Insert(movie *Movie, userID int64) error {
 	return nil
 }
 
 func (m MockMovieModel) Get(id int64) (*Movie, error) {
 	return nil, nil
 }
-func (m MockMovieModel) Update(movie *Movie) error {
+func (m MockMovieModel) Update(movie *Movie, userID int64) error {
 	return nil
 }
-func (m MockMovieModel) Delete(id int64) error {
+func (m MockMovieModel) Delete(id int64, version int32, userID int64) error {
 	return nil
 }
 
-func (m MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MockMovieModel) GetAll(criteria MovieFilters, filters Filters, fields MovieFields) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
 
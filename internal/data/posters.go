@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// PosterOriginal is the variant name for a poster image as it was uploaded.
+const PosterOriginal = "original"
+
+// PosterWidths holds the resized variants that we make of each poster, by name, with
+// the width of each in pixels.
+var PosterWidths = map[string]int{
+	"w185": 185,
+	"w500": 500,
+}
+
+// Poster is the file name of a movie's poster image, like "42-9f86d081884c7d65.jpg".
+// Each variant of the poster is stored under the same name, and in JSON it appears as
+// the URL of the original image.
+type Poster string
+
+// Key() returns the storage key of one variant of the poster.
+func (p Poster) Key(variant string) string {
+	return "posters/" + variant + "/" + string(p)
+}
+
+// URL() returns the path that one variant of the poster is served from.
+func (p Poster) URL(variant string) string {
+	return "/v1/" + p.Key(variant)
+}
+
+// URLs() returns the paths of every variant of the poster, keyed by variant name.
+func (p Poster) URLs() map[string]string {
+	urls := map[string]string{PosterOriginal: p.URL(PosterOriginal)}
+	for variant := range PosterWidths {
+		urls[variant] = p.URL(variant)
+	}
+	return urls
+}
+
+// MarshalJSON() sends the poster to clients as the URL of the original image.
+func (p Poster) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(p.URL(PosterOriginal))), nil
+}
+
+// SetPoster() changes a movie's poster and returns the one it replaces, if any, so
+// that the caller can delete its files. Like the ratings, the poster isn't part of the
+// movie's version, so this doesn't record a revision.
+func (m MovieModel) SetPoster(id int64, poster Poster) (Poster, error) {
+	query := `
+		WITH old AS (
+			SELECT id, poster FROM movies
+			WHERE id = $1 AND deleted_at IS NULL
+			FOR UPDATE
+		)
+		UPDATE movies
+		SET poster = $2, updated_at = NOW()
+		FROM old
+		WHERE movies.id = old.id
+		RETURNING old.poster`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var old Poster
+	err := m.DB.QueryRowContext(ctx, query, id, poster).Scan(&old)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return old, nil
+}
@@ -0,0 +1,300 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// ErrDuplicateReview is returned when a user tries to review a movie a second time.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review is a user's rating of a movie out of 10, with an optional written review.
+// Each user can review a movie once, and edit their review after.
+type Review struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// updateRatingsQuery brings the rating_count and average_rating of the movie with the
+// ID in $1 up to date with its reviews. The ratings are part of the movie's
+// representation, so changing them moves on updated_at too (see Movie.UpdatedAt).
+const updateRatingsQuery = `
+	UPDATE movies
+	SET rating_count = ratings.count, average_rating = ratings.average, updated_at = NOW()
+	FROM (
+		SELECT count(*) AS count, COALESCE(round(avg(rating), 2), 0) AS average
+		FROM reviews
+		WHERE movie_id = $1
+	) AS ratings
+	WHERE movies.id = $1`
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// The withRatings() helper runs a change to the reviews of a movie in a transaction,
+// and then brings the movie's rating_count and average_rating up to date. The movie row
+// is locked first, so that changes to the reviews of the same movie take turns and
+// each one works out the aggregates with the others' changes included. If the movie
+// doesn't exist (or is in the trash), we return ErrRecordNotFound.
+func (m ReviewModel) withRatings(movieID int64, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, updateRatingsQuery, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Insert() adds a review, returning ErrDuplicateReview if the user has reviewed the
+// movie already.
+func (m ReviewModel) Insert(review *Review) error {
+	return m.withRatings(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			INSERT INTO reviews (movie_id, user_id, rating, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, updated_at, version`
+
+		args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Body}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get() returns a review of a movie by its ID.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT reviews.id, reviews.movie_id, reviews.user_id, users.name, reviews.rating, reviews.body,
+			reviews.created_at, reviews.updated_at, reviews.version
+		FROM reviews
+		INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.movie_id = $1 AND reviews.id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, id).Scan(
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+// Update() saves the changes to a review, using the version number to catch edit
+// conflicts in the same way as MovieModel.Update().
+func (m ReviewModel) Update(review *Review) error {
+	return m.withRatings(review.MovieID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+			UPDATE reviews
+			SET rating = $1, body = $2, updated_at = NOW(), version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING updated_at, version`
+
+		args := []interface{}{review.Rating, review.Body, review.ID, review.Version}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflit
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete() removes a review of a movie.
+func (m ReviewModel) Delete(movieID, id int64) error {
+	return m.withRatings(movieID, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE movie_id = $1 AND id = $2`, movieID, id)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// GetAllForMovie() returns a page of the reviews of a movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	// The join happens in a subquery, so that the sort columns (like id) aren't
+	// ambiguous between reviews and users.
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, user_id, user_name, rating, body, created_at, updated_at, version
+		FROM (
+			SELECT reviews.*, users.name AS user_name
+			FROM reviews
+			INNER JOIN users ON users.id = reviews.user_id
+			WHERE reviews.movie_id = $1
+		) AS reviews
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	totalRecords := 0
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// GetRecentForMovies() returns up to limit of the most recent reviews of each of
+// several movies, newest first and keyed by movie ID, for embedding in a page of
+// movies. Movies without any reviews get an empty slice.
+func (m ReviewModel) GetRecentForMovies(movieIDs []int64, limit int) (map[int64][]*Review, error) {
+	query := `
+		SELECT id, movie_id, user_id, user_name, rating, body, created_at, updated_at, version
+		FROM (
+			SELECT reviews.*, users.name AS user_name,
+				row_number() OVER (PARTITION BY reviews.movie_id ORDER BY reviews.created_at DESC, reviews.id DESC) AS n
+			FROM reviews
+			INNER JOIN users ON users.id = reviews.user_id
+			WHERE reviews.movie_id = ANY($1)
+		) AS reviews
+		WHERE n <= $2
+		ORDER BY movie_id, n`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := make(map[int64][]*Review, len(movieIDs))
+	for _, id := range movieIDs {
+		reviews[id] = []*Review{}
+	}
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reviews[review.MovieID] = append(reviews[review.MovieID], &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
@@ -0,0 +1,191 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The actions recorded in the movie revision history. RevisionBaseline marks the
+// snapshot taken of each movie that existed when the history was introduced.
+const (
+	RevisionBaseline = "baseline"
+	RevisionInsert   = "insert"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRestore  = "restore"
+)
+
+// Revision is a snapshot of a movie as it was saved at a particular version, along
+// with what was done to it and by whom. UserID is nil for changes that weren't made
+// by a user (and for users that have since been deleted).
+type Revision struct {
+	Version   int32     `json:"version"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Movie     Movie     `json:"movie"`
+}
+
+// revisionInsert() returns an INSERT statement which records a revision for each row
+// of a CTE named movie, for the action done by the user whose ID is in the userArg
+// placeholder (zero for no user). The movie write methods combine it with their own
+// query, so that a change and its revision are always saved together.
+func revisionInsert(action, userArg string) string {
+	return fmt.Sprintf(`INSERT INTO movie_revisions (movie_id, version, action, user_id, title, year, runtime, genres)
+		SELECT id, version, '%s', NULLIF(%s::bigint, 0), title, year, runtime, genres FROM movie`, action, userArg)
+}
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie() returns a page of the revision history of a movie. The history
+// is kept for deleted movies too, until they are purged.
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*Revision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), version, action, user_id, created_at, movie_id, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	totalRecords := 0
+	for rows.Next() {
+		var revision Revision
+		err := rows.Scan(
+			&totalRecords,
+			&revision.Version,
+			&revision.Action,
+			&revision.UserID,
+			&revision.CreatedAt,
+			&revision.Movie.ID,
+			&revision.Movie.Title,
+			&revision.Movie.Year,
+			&revision.Movie.Runtime,
+			pq.Array(&revision.Movie.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revision.Movie.Version = revision.Version
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// Get() returns the revision of a movie at a specific version, or ErrRecordNotFound
+// if there isn't one.
+func (m RevisionModel) Get(movieID int64, version int32) (*Revision, error) {
+	query := `
+		SELECT version, action, user_id, created_at, movie_id, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var revision Revision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.Version,
+		&revision.Action,
+		&revision.UserID,
+		&revision.CreatedAt,
+		&revision.Movie.ID,
+		&revision.Movie.Title,
+		&revision.Movie.Year,
+		&revision.Movie.Runtime,
+		pq.Array(&revision.Movie.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	revision.Movie.Version = revision.Version
+	return &revision, nil
+}
+
+// FieldChange describes how a single movie field differs between two revisions. For
+// list fields like genres, Added and Removed hold the individual values that changed.
+type FieldChange struct {
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// DiffRevisions() compares the movie snapshots in two revisions, returning the changes
+// keyed by JSON field name. Fields which are the same in both are left out.
+func DiffRevisions(from, to *Revision) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	a, b := from.Movie, to.Movie
+	if a.Title != b.Title {
+		changes["title"] = FieldChange{From: a.Title, To: b.Title}
+	}
+	if a.Year != b.Year {
+		changes["year"] = FieldChange{From: a.Year, To: b.Year}
+	}
+	if a.Runtime != b.Runtime {
+		changes["runtime"] = FieldChange{From: a.Runtime, To: b.Runtime}
+	}
+	if !equalStrings(a.Genres, b.Genres) {
+		changes["genres"] = FieldChange{
+			From:    a.Genres,
+			To:      b.Genres,
+			Added:   difference(b.Genres, a.Genres),
+			Removed: difference(a.Genres, b.Genres),
+		}
+	}
+	return changes
+}
+
+// difference() returns the values in a which aren't in b.
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, value := range b {
+		in[value] = true
+	}
+	var diff []string
+	for _, value := range a {
+		if !in[value] {
+			diff = append(diff, value)
+		}
+	}
+	return diff
+}
+
+// equalStrings() reports whether two slices hold the same values in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The deleteTokens() helper deletes the tokens matching the where condition, and
+// returns how many there were. When m.RecordRevocations is set, the authentication
+// tokens among them which haven't expired yet are added to revoked_tokens in the same
+// statement, so that signed copies of them stop working too.
+func (m TokenModel) deleteTokens(ctx context.Context, db queryRower, where string, args ...interface{}) (int64, error) {
+	query := `
+WITH deleted AS (
+	DELETE FROM tokens WHERE ` + where + `
+	RETURNING id, scope, expiry
+)`
+	if m.RecordRevocations {
+		query += `, revoked AS (
+	INSERT INTO revoked_tokens (id, expiry)
+	SELECT id, expiry FROM deleted WHERE scope = '` + ScopeAuthentication + `' AND expiry > NOW()
+	ON CONFLICT DO NOTHING
+)`
+	}
+	query += `
+SELECT count(*) FROM deleted`
+
+	var n int64
+	err := db.QueryRowContext(ctx, query, args...).Scan(&n)
+	return n, err
+}
+
+// GetRevoked() returns the IDs of the revoked authentication tokens that haven't
+// expired yet, with their expiry times.
+func (m TokenModel) GetRevoked() (map[string]time.Time, error) {
+	query := `
+SELECT id, expiry
+FROM revoked_tokens
+WHERE expiry > NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var expiry time.Time
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		revoked[id] = expiry
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// PurgeRevoked() deletes the revoked tokens which have expired, since they no longer
+// need to be listed.
+func (m TokenModel) PurgeRevoked() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expiry <= NOW()`)
+	return err
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultSearchConfig is the PostgreSQL text search configuration used to build and
+// query the movie search vectors, unless the deployment configures another one.
+const DefaultSearchConfig = "english"
+
+// prefixTermRX matches a single search word ending in "*", optionally negated with a
+// leading "-". Only letters and digits are allowed so that the term can be handed to
+// to_tsquery() without any escaping.
+var prefixTermRX = regexp.MustCompile(`^(-?)([\p{L}\p{N}]+)\*$`)
+
+// The parseSearchQuery() helper splits the q search string into the part that we pass
+// to websearch_to_tsquery(), which understands "quoted phrases", OR and -negation, and
+// a to_tsquery() expression for any prefix terms like "galax*", which websearch
+// syntax doesn't support. Prefix terms are always ANDed with the rest of the query.
+func parseSearchQuery(q string) (websearch string, prefix string) {
+	var web, prefixes []string
+	inQuotes := false
+	for _, field := range strings.Fields(q) {
+		if !inQuotes {
+			if m := prefixTermRX.FindStringSubmatch(field); m != nil {
+				term := strings.ToLower(m[2]) + ":*"
+				if m[1] == "-" {
+					term = "!" + term
+				}
+				prefixes = append(prefixes, term)
+				continue
+			}
+		}
+		if strings.Count(field, `"`)%2 == 1 {
+			inQuotes = !inQuotes
+		}
+		web = append(web, field)
+	}
+	return strings.Join(web, " "), strings.Join(prefixes, " & ")
+}
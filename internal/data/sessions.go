@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrTokenReused is returned when a refresh token that has already been traded in is
+// presented again. Only one of the two can be the real client, so the whole session is
+// revoked.
+var ErrTokenReused = errors.New("refresh token reused")
+
+// sessionScopes are the scopes of the tokens that make up a session.
+var sessionScopes = []string{ScopeAuthentication, ScopeRefresh}
+
+// Session is a sign-in as the user sees it when managing where they're signed in: a
+// family of authentication and refresh tokens. ID is the family's opaque identifier,
+// which unlike the tokens themselves is safe to show, and stays the same as the tokens
+// are refreshed. CreatedAt is when the user signed in, while IP and UserAgent are from
+// the latest refresh. LastUsedAt is nil until a token is first used, and is only
+// updated every minute or so (see Touch()), or in the signed token mode, when the
+// tokens are refreshed.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	// Current is set on the session of the token that the request was made with.
+	Current bool `json:"current"`
+}
+
+// NewSession() signs a user in, issuing a new family with an authentication token and
+// a refresh token. The IP address and user agent of the client are recorded with
+// them, so that the user can tell their sessions apart.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err = issueTokenPair(ctx, tx, userID, "", accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// Refresh() trades a refresh token in for a new authentication token and refresh
+// token in the same family, revoking the family's old authentication token. The old
+// refresh token is kept, marked as used, and if it's ever presented again we revoke
+// the whole family and return ErrTokenReused, since it must have been stolen by
+// either the client or whoever presented it first. An unknown or expired refresh token
+// gets ErrRecordNotFound.
+func (m TokenModel) Refresh(refreshPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Marking the token as used in the same statement that checks it means that
+	// only one of two simultaneous refreshes with the same token can succeed.
+	query := `
+UPDATE tokens
+SET used_at = NOW(), last_used_at = NOW()
+WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > NOW()
+RETURNING user_id, family`
+	var userID int64
+	var family string
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&userID, &family)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, m.revokeReused(tokenHash[:])
+		}
+		return nil, nil, err
+	}
+
+	_, err = m.deleteTokens(ctx, tx, `family = $1 AND scope = $2`, family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	access, refresh, err = issueTokenPair(ctx, tx, userID, family, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// The revokeReused() helper is for a refresh token that Refresh() couldn't use. If it
+// has been used before, it deletes the token's whole family and returns
+// ErrTokenReused. Otherwise the token is unknown or expired, and it returns
+// ErrRecordNotFound.
+func (m TokenModel) revokeReused(tokenHash []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	where := `family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL)`
+	n, err := m.deleteTokens(ctx, m.DB, where, tokenHash, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrTokenReused
+	}
+	return ErrRecordNotFound
+}
+
+// The issueTokenPair() helper inserts a new authentication token and refresh token for
+// the user, in the given family or a new one if family is empty.
+func issueTokenPair(ctx context.Context, tx *sql.Tx, userID int64, family string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (access, refresh *Token, err error) {
+	// The user agent is only for display, so there's no need to keep all of an
+	// unusually long one. Cutting it short may split a character in two, which we
+	// drop rather than store invalid UTF-8.
+	if len(userAgent) > 500 {
+		userAgent = strings.ToValidUTF8(userAgent[:500], "")
+	}
+
+	access, err = generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The authentication token goes in first, so that a new family gets its ID
+	// from the database before the refresh token joins it.
+	for _, token := range []*Token{access, refresh} {
+		token.IP, token.UserAgent, token.Family = ip, userAgent, family
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+		family = token.Family
+	}
+	return access, refresh, nil
+}
+
+// GetSessions() returns the user's sessions that still have an unexpired token,
+// most recently used first. The session that currentToken belongs to, if any, is
+// marked as the current session.
+func (m TokenModel) GetSessions(userID int64, currentToken string) ([]*Session, error) {
+	// Used refresh tokens count towards when the session started and was last
+	// used, but not towards when it expires.
+	query := `
+SELECT family, min(created_at), max(last_used_at), max(expiry) FILTER (WHERE used_at IS NULL),
+	(array_agg(ip ORDER BY created_at DESC))[1], (array_agg(user_agent ORDER BY created_at DESC))[1],
+	bool_or(hash = $3)
+FROM tokens
+WHERE user_id = $1 AND scope = ANY($2)
+GROUP BY family
+HAVING bool_or(used_at IS NULL AND expiry > NOW())
+ORDER BY COALESCE(max(last_used_at), min(created_at)) DESC, min(created_at) DESC, family`
+
+	currentHash := sha256.Sum256([]byte(currentToken))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, pq.Array(sessionScopes), currentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession() revokes one of the user's sessions by deleting all of its tokens. If
+// the user has no session with that ID, we return ErrRecordNotFound.
+func (m TokenModel) DeleteSession(userID int64, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The ID is compared as text, so that an ID which isn't a valid UUID simply
+	// doesn't match anything.
+	n, err := m.deleteTokens(ctx, m.DB, `user_id = $1 AND scope = ANY($2) AND family::text = $3`, userID, pq.Array(sessionScopes), id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteSessionForToken() revokes the session that an authentication token belongs
+// to, refresh tokens and all. It's not an error if there's no such token.
+func (m TokenModel) DeleteSessionForToken(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.deleteTokens(ctx, m.DB, `family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`, tokenHash[:], ScopeAuthentication)
+	return err
+}
+
+// Touch() records that an authentication token has just been used. Each server only
+// calls it about once a minute for a token, and the time is only updated when it's more
+// than a minute old, so that several servers between them don't write it more often.
+func (m TokenModel) Touch(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+UPDATE tokens
+SET last_used_at = NOW()
+WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
+	return err
+}
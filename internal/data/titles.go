@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"uwDavid/moviedb/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// LocaleRX matches a BCP 47 language tag in the forms that we use for titles, like
+// "fr", "pt-BR" or "zh-Hant-TW".
+var LocaleRX = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// CanonicalLocale() returns a language tag in its usual case: the language in lower
+// case, a script in title case and a region in upper case, as in "zh-Hant-TW". Tags
+// are compared in this form, so that "PT-br" and "pt-BR" are the same locale.
+func CanonicalLocale(tag string) string {
+	parts := strings.Split(tag, "-")
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part)
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Titles holds a movie's alternate titles, keyed by locale. It's read from the
+// database as a JSON object.
+type Titles map[string]string
+
+// Scan() implements the sql.Scanner interface, so that the JSON object built by the
+// titlesColumn() expression can be scanned straight into a Titles map. NULL means that
+// the movie has no alternate titles.
+func (t *Titles) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, t)
+	case string:
+		return json.Unmarshal([]byte(src), t)
+	}
+	return fmt.Errorf("cannot scan %T into Titles", src)
+}
+
+// Lookup() picks the best title for a list of locales in order of preference. Each
+// locale is tried as it is and then with its last subtags removed, so "fr-CA" falls
+// back to "fr", and failing that any title in the same language will do. If none of
+// the locales has a title, ok is false and the original title should be used.
+func (t Titles) Lookup(locales []string) (locale string, ok bool) {
+	for _, wanted := range locales {
+		for tag := CanonicalLocale(wanted); tag != ""; {
+			if _, ok := t[tag]; ok {
+				return tag, true
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+		language, _, _ := strings.Cut(CanonicalLocale(wanted), "-")
+		for _, tag := range t.locales() {
+			if strings.HasPrefix(tag, language+"-") {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// The locales() helper returns the locales in sorted order.
+func (t Titles) locales() []string {
+	locales := make([]string, 0, len(t))
+	for locale := range t {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Hash() returns a short hash of the titles, for including in entity tags. It's empty
+// when there are no titles.
+func (t Titles) Hash() string {
+	if len(t) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, locale := range t.locales() {
+		fmt.Fprintf(h, "%s=%s\n", locale, t[locale])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+func ValidateTitles(v *validator.Validator, titles Titles) {
+	v.Check(len(titles) <= 50, "titles", "must not contain more than 50 titles")
+	seen := make(map[string]bool, len(titles))
+	for locale, title := range titles {
+		if !LocaleRX.MatchString(locale) {
+			v.AddError("titles", fmt.Sprintf("contains invalid locale %q", locale))
+			continue
+		}
+		v.Check(!seen[CanonicalLocale(locale)], "titles", fmt.Sprintf("contains locale %q more than once", locale))
+		seen[CanonicalLocale(locale)] = true
+		v.Check(title != "", "titles", fmt.Sprintf("must not contain an empty title for %q", locale))
+		v.Check(len(title) <= 500, "titles", fmt.Sprintf("must not contain a title for %q more than 500 bytes long", locale))
+	}
+}
+
+// titlesColumn() returns the SQL expression for the alternate titles of the movie with
+// the id expression, as a JSON object for scanning into Titles.
+func titlesColumn(id string) string {
+	return fmt.Sprintf(`(SELECT json_object_agg(movie_titles.locale, movie_titles.title) FROM movie_titles WHERE movie_titles.movie_id = %s)`, id)
+}
+
+// searchVector() returns the SQL expression for a movie's search vector, which covers
+// its original title and all of its alternate titles, so that searches in any
+// language find it.
+func searchVector(config, title, id string) string {
+	return fmt.Sprintf(`(to_tsvector(%[1]s, %[2]s) || to_tsvector(%[1]s,
+		(SELECT COALESCE(string_agg(movie_titles.title, ' '), '') FROM movie_titles WHERE movie_titles.movie_id = %[3]s)))`, config, title, id)
+}
+
+// SetTitles() replaces all of a movie's alternate titles, and rebuilds its search
+// vector to match. Like the poster, the titles aren't part of the movie's version, so
+// to make sure that nobody else has changed the movie or its titles since it was
+// fetched, we check both its version and its current titles against the ones in movie
+// once its row is locked, and return an ErrEditConflit error if either has moved on.
+func (m MovieModel) SetTitles(movie *Movie, titles Titles) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT version, ` + titlesColumn("movies.id") + `
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`
+	var version int32
+	var current Titles
+	err = tx.QueryRowContext(ctx, query, movie.ID).Scan(&version, &current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if version != movie.Version || current.Hash() != movie.Titles.Hash() {
+		return ErrEditConflit
+	}
+
+	id := movie.ID
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_titles WHERE movie_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	locales, values := make([]string, 0, len(titles)), make([]string, 0, len(titles))
+	for locale, title := range titles {
+		locales = append(locales, CanonicalLocale(locale))
+		values = append(values, title)
+	}
+	query = `
+		INSERT INTO movie_titles (movie_id, locale, title)
+		SELECT $1, locale, title FROM unnest($2::text[], $3::text[]) AS titles(locale, title)`
+	_, err = tx.ExecContext(ctx, query, id, pq.Array(locales), pq.Array(values))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE movies
+		SET search_vector = ` + searchVector("$2::regconfig", "movies.title", "movies.id") + `, updated_at = NOW()
+		WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, id, m.SearchConfig)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 	"uwDavid/moviedb/internal/validator"
 )
@@ -13,14 +14,35 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	// ScopePasswordReset tokens are emailed to users who have forgotten their
+	// password, and let them set a new one.
+	ScopePasswordReset = "password-reset"
+	// ScopeRefresh tokens are handed out with authentication tokens, and can be
+	// traded in once for a new pair when the authentication token runs out.
+	ScopeRefresh = "refresh"
 )
 
+// ErrTokenThrottled is returned by Reissue() when the user was issued a token with the
+// same scope too recently.
+var ErrTokenThrottled = errors.New("token throttled")
+
 type Token struct {
+	// ID is the token's opaque identifier, which unlike the token itself isn't
+	// secret.
+	ID        string    `json:"-"`
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	CreatedAt time.Time `json:"-"`
+	// IP and UserAgent record the client that an authentication token was issued
+	// to, for listing the user's sessions.
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+	// Family is shared by all of the authentication and refresh tokens issued from
+	// one sign-in (see sessions.go). Other tokens are a family of their own.
+	Family string `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -66,9 +88,11 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-// Define the TokenModel type.
+// Define the TokenModel type. When RecordRevocations is set, deleted authentication
+// tokens are listed in revoked_tokens (see revocations.go), for the signed token mode.
 type TokenModel struct {
-	DB *sql.DB
+	DB                *sql.DB
+	RecordRevocations bool
 }
 
 // The New() method is a shortcut which creates a new Token struct and then inserts the
@@ -84,23 +108,87 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertToken(ctx, m.DB, token)
+}
+
+// queryRower is the QueryRowContext() method that *sql.DB and *sql.Tx have in common,
+// so that insertToken() can be used inside a transaction too.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// The insertToken() helper does the work for Insert(). A token without a Family
+// starts a new one.
+func insertToken(ctx context.Context, db queryRower, token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family)
+VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, '')::uuid, gen_random_uuid()))
+RETURNING id, created_at, family`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family}
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt, &token.Family)
+}
+
+// Reissue() replaces the user's tokens with the given scope with a new one, unless the
+// user was issued one less than interval ago, in which case it returns
+// ErrTokenThrottled. The check is part of the INSERT, and the user's row stays locked
+// until we're done, so that two requests at once can't both get past it.
+func (m TokenModel) Reissue(userID int64, ttl time.Duration, scope string, interval time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query := `
+INSERT INTO tokens (hash, user_id, expiry, scope)
+SELECT $1, $2, $3, $4
+WHERE NOT EXISTS (
+	SELECT 1 FROM tokens
+	WHERE user_id = $2 AND scope = $4 AND created_at > NOW() - make_interval(secs => $5)
+)
+RETURNING id, created_at, family`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, interval.Seconds()}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt, &token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrTokenThrottled
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = m.deleteTokens(ctx, tx, `scope = $1 AND user_id = $2 AND id <> $3`, scope, userID, token.ID)
+	if err != nil {
+		return nil, err
+	}
+	return token, tx.Commit()
 }
 
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
-	query := `
-DELETE FROM tokens
-WHERE scope = $1 AND user_id = $2`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	_, err := m.deleteTokens(ctx, m.DB, `scope = $1 AND user_id = $2`, scope, userID)
 	return err
 }
@@ -0,0 +1,66 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Resize() scales an image down to the given width, keeping its aspect ratio. Each
+// pixel of the result is the average of the block of pixels it covers in the
+// original, which is all that's needed for shrinking. Images which are no wider than
+// width already are returned as they are.
+func Resize(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if width < 1 || srcW <= width {
+		return img
+	}
+	height := max(srcH*width/srcW, 1)
+
+	// Work on a copy in RGBA form, so that we can read the pixels directly. Its colors
+	// are premultiplied by alpha, which makes averaging them correct for transparent
+	// pixels too.
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcH/height, (y+1)*srcH/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcW/width, (x+1)*srcW/width
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[i+c])
+					}
+					i += 4
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Encode() writes an image in the named format, as returned by image.Decode(). Only
+// "jpeg" and "png" are supported.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	}
+	return fmt.Errorf("imaging: unsupported format %q", format)
+}
@@ -0,0 +1,177 @@
+// Package jwt signs and verifies the self-contained access tokens that we issue in the
+// signed token mode. They're JSON Web Tokens using the HS256 algorithm (HMAC-SHA256).
+//
+// Each signing key has an ID, which goes in the kid field of the token header, so that
+// keys can be rotated: a new key is put first to sign with, and the old one is kept
+// after it for verifying until the last of its tokens have expired.
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// minSecretLength is the shortest secret we accept for a key, which is the length of
+// the HMAC-SHA256 output.
+const minSecretLength = 32
+
+// Claims is the payload of an access token. Everything needed to authenticate and
+// authorize a request is in it, so that they can be checked without a database
+// query. ID is the ID of the token (its row in the tokens table), which is what gets
+// revoked, and SessionID is the ID of the session (token family) it belongs to.
+// IssuedAt and Expiry are Unix times.
+type Claims struct {
+	ID          string   `json:"jti"`
+	SessionID   string   `json:"sid"`
+	UserID      int64    `json:"uid"`
+	Name        string   `json:"name"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+}
+
+// header is the JOSE header of a token.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a signing key, with the ID that tokens refer to it by.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys() parses signing keys in the form used by the -token-signing-keys flag and
+// the keys file: a list of id=secret pairs separated by spaces or newlines, with each
+// secret in base64. The first key is the one to sign with.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, field := range strings.Fields(s) {
+		id, secret, ok := strings.Cut(field, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("signing key %q is not in the form id=secret", field)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: secret is not valid base64", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: decoded})
+	}
+	return keys, nil
+}
+
+// Signer signs tokens with the first of its keys, and verifies tokens signed with any
+// of them.
+type Signer struct {
+	current Key
+	keys    map[string][]byte
+}
+
+// NewSigner() returns a Signer for the keys, the first of which is used for signing.
+func NewSigner(keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	s := &Signer{current: keys[0], keys: make(map[string][]byte, len(keys))}
+	for _, key := range keys {
+		if len(key.Secret) < minSecretLength {
+			return nil, fmt.Errorf("signing key %q: secret must be at least %d bytes long", key.ID, minSecretLength)
+		}
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("signing key %q is given more than once", key.ID)
+		}
+		s.keys[key.ID] = key.Secret
+	}
+	return s, nil
+}
+
+// LooksSigned() reports whether a token is in the three-part form of a signed token,
+// as opposed to one of our opaque tokens.
+func LooksSigned(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Sign() returns a signed token for the claims.
+func (s *Signer) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyID: s.current.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := encode(h) + "." + encode(payload)
+	return unsigned + "." + encode(sign(s.current.Secret, unsigned)), nil
+}
+
+// Verify() checks a token's signature and expiry, returning its claims. A token that
+// is malformed, uses another algorithm, refers to a key we don't have or has a bad
+// signature gets ErrInvalidToken, and an expired one gets ErrExpiredToken.
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err := decodeJSON(parts[0], &h)
+	if err != nil || h.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	secret, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	// Only now that we know we signed the payload is it worth decoding.
+	var claims Claims
+	err = decodeJSON(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// The sign() helper returns the HMAC-SHA256 of the unsigned part of a token.
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// The encode() helper encodes a part of a token in unpadded URL-safe base64, as JWTs
+// are.
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The decodeJSON() helper decodes a base64-encoded JSON part of a token into dst.
+func decodeJSON(part string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = Key{ID: "2023", Secret: bytes.Repeat([]byte("o"), minSecretLength)}
+	newKey = Key{ID: "2024", Secret: bytes.Repeat([]byte("n"), minSecretLength)}
+)
+
+// The mustSigner() helper returns a Signer for the keys, failing the test if they're
+// rejected.
+func mustSigner(t *testing.T, keys ...Key) *Signer {
+	t.Helper()
+	s, err := NewSigner(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// The craft() helper builds a token with any header and claims, signed with secret, for
+// the cases that Sign() can't produce.
+func craft(t *testing.T, h header, claims Claims, secret []byte) string {
+	t.Helper()
+	hj, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cj, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := encode(hj) + "." + encode(cj)
+	return unsigned + "." + encode(sign(secret, unsigned))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := Claims{
+		ID:          "0b6d3c4e-5d4f-4a53-9d3c-6b0d5d2b3b1a",
+		SessionID:   "8f1e2a6c-0c1b-4d8e-9a57-2f9f0d9b7e42",
+		UserID:      42,
+		Name:        "Alice",
+		Activated:   true,
+		Permissions: []string{"movies:read"},
+		IssuedAt:    now.Unix(),
+		Expiry:      now.Add(15 * time.Minute).Unix(),
+	}
+
+	signed, err := mustSigner(t, newKey, oldKey).Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedWithOld, err := mustSigner(t, oldKey).Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := claims
+	expired.Expiry = now.Unix()
+	signedExpired, err := mustSigner(t, newKey).Sign(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered token keeps the original header and signature, with the payload
+	// swapped for one that grants the user another permission.
+	parts := strings.Split(signed, ".")
+	escalated := claims
+	escalated.Permissions = []string{"movies:read", "movies:write"}
+	payload, err := json.Marshal(escalated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := parts[0] + "." + encode(payload) + "." + parts[2]
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		token  string
+		want   error
+	}{
+		{"valid", mustSigner(t, newKey), signed, nil},
+		{"signed with an older key still in the set", mustSigner(t, newKey, oldKey), signedWithOld, nil},
+		{"signed with a rotated-out key", mustSigner(t, newKey), signedWithOld, ErrInvalidToken},
+		{"tampered payload", mustSigner(t, newKey), tampered, ErrInvalidToken},
+		{"unknown kid", mustSigner(t, newKey), craft(t, header{Algorithm: "HS256", Type: "JWT", KeyID: "nope"}, claims, newKey.Secret), ErrInvalidToken},
+		{"alg none", mustSigner(t, newKey), craft(t, header{Algorithm: "none", Type: "JWT", KeyID: newKey.ID}, claims, newKey.Secret), ErrInvalidToken},
+		{"alg HS512", mustSigner(t, newKey), craft(t, header{Algorithm: "HS512", Type: "JWT", KeyID: newKey.ID}, claims, newKey.Secret), ErrInvalidToken},
+		{"expired", mustSigner(t, newKey), signedExpired, ErrExpiredToken},
+		{"malformed", mustSigner(t, newKey), "not.a-token", ErrInvalidToken},
+		{"bad signature encoding", mustSigner(t, newKey), parts[0] + "." + parts[1] + ".!!!", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Verify(tt.token, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v; want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if got.UserID != claims.UserID || got.ID != claims.ID || got.SessionID != claims.SessionID {
+				t.Errorf("got claims %+v; want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr bool
+	}{
+		{"one key", []Key{newKey}, false},
+		{"rotation", []Key{newKey, oldKey}, false},
+		{"no keys", nil, true},
+		{"short secret", []Key{{ID: "short", Secret: []byte("secret")}}, true},
+		{"duplicate ID", []Key{newKey, {ID: newKey.ID, Secret: oldKey.Secret}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSigner(tt.keys...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"space separated", "a=c2VjcmV0 b=b3RoZXI=", []string{"a", "b"}, false},
+		{"newline separated", "a=c2VjcmV0\nb=b3RoZXI=\n", []string{"a", "b"}, false},
+		{"empty", "", nil, false},
+		{"missing secret", "a", nil, true},
+		{"missing ID", "=c2VjcmV0", nil, true},
+		{"bad base64", "a=!!!", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.wantIDs) {
+				t.Fatalf("got %d keys; want %d", len(keys), len(tt.wantIDs))
+			}
+			for i, key := range keys {
+				if key.ID != tt.wantIDs[i] {
+					t.Errorf("got key %q at %d; want %q", key.ID, i, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by Open() and Delete() when there is no file with the key.
+	ErrNotFound = errors.New("storage: file not found")
+	// ErrInvalidKey is returned for keys which aren't slash-separated relative paths,
+	// such as ones containing "..".
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Storage is where uploaded files, like movie posters, are kept. Files are named by
+// keys, which are slash-separated relative paths like "posters/original/1.jpg".
+type Storage interface {
+	// Put() saves the contents of r under the key, replacing any file already there.
+	Put(key string, r io.Reader) error
+	// Open() returns the file with the key, along with the time it was last changed.
+	// The caller must close it.
+	Open(key string) (io.ReadSeekCloser, time.Time, error)
+	// Delete() removes the file with the key.
+	Delete(key string) error
+}
+
+// Local is a Storage which keeps files in a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+// NewLocal() returns a Local storage for the directory, creating it if it doesn't
+// exist.
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+// The path() helper turns a key into a path inside the storage directory, making sure
+// that it can't point anywhere outside it.
+func (l *Local) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put() writes the file to a temporary name first and then renames it, so that a
+// reader never sees a half-written file.
+func (l *Local) Put(key string, r io.Reader) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (l *Local) Open(key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, time.Time{}, ErrNotFound
+	}
+	return f, info.ModTime(), nil
+}
+
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}